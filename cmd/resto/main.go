@@ -0,0 +1,358 @@
+// Resto browses and edits the resources mapped by a server using the rest
+// package.
+//
+// Usage:
+//
+//	resto [flags] command [arguments]
+//
+// The commands are:
+//
+//	ls [path]           list the resources and members below path
+//	get path            print the value at path
+//	put path value      replace the value at path
+//	patch path value    merge a JSON merge patch into the value at path
+//	rm path             remove the value at path
+//	watch path          print the value at path each time it changes
+//	shell               read commands interactively
+//
+// Values are JSON; a value which is not valid JSON is sent as a string, and a
+// value of "-" is read from standard input.  Strings are printed as plain
+// text unless -json is given.  In the shell, paths are relative to the
+// directory chosen with cd, and the tab key completes commands and paths.
+//
+// The exit status is 0 on success, 4 or 5 if the server replied with a client
+// (4xx) or server (5xx) error, 2 if the command line is wrong, and 1 for any
+// other error.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"json"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kylelemons/go-resto/rest"
+	"github.com/kylelemons/go-resto/rest/client"
+)
+
+var (
+	server  = flag.String("server", "http://localhost:8080", "base URL of the server")
+	jsonOut = flag.Bool("json", false, "print strings as JSON")
+)
+
+// A command is one of the things resto can do.  Its arguments are the paths
+// and values given on the command line.
+type command struct {
+	name string
+	args string
+	help string
+	min  int
+	max  int
+	run  func(args []string) os.Error
+}
+
+var commands []*command
+
+func init() {
+	// Initialized here since shell refers to commands
+	commands = []*command{
+		{"ls", "[path]", "list the resources and members below path", 0, 1, ls},
+		{"get", "path", "print the value at path", 1, 1, get},
+		{"put", "path value", "replace the value at path", 2, 2, put},
+		{"patch", "path value", "merge a JSON merge patch into the value at path", 2, 2, patch},
+		{"rm", "path", "remove the value at path", 1, 1, rm},
+		{"watch", "path", "print the value at path each time it changes", 1, 1, watch},
+		{"shell", "", "read commands interactively", 0, 0, shell},
+	}
+}
+
+// lookup returns the command with the given name, or nil.
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// A usageError is returned when a command is given the wrong arguments.
+type usageError string
+
+func (e usageError) String() string {
+	return string(e)
+}
+
+// exitStatus returns the exit status for the error with which a command
+// failed.
+func exitStatus(err os.Error) int {
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case usageError:
+		return 2
+	case rest.ErrorCoder:
+		return err.ErrorCode() / 100
+	case *rest.FailedEncode:
+		// The server replies to errors without codes with 500
+		return 5
+	}
+	return 1
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: resto [flags] command [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s%s\n", cmd.name+" "+cmd.args, cmd.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	conn = client.New(*server)
+	err := run(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "resto: %s\n", err)
+	}
+	if _, ok := err.(usageError); ok {
+		usage()
+	}
+	os.Exit(exitStatus(err))
+}
+
+// run runs the command named by the first argument.
+func run(args []string) os.Error {
+	cmd := lookup(args[0])
+	if cmd == nil {
+		return usageError("unknown command " + strconv.Quote(args[0]))
+	}
+	args = args[1:]
+	if len(args) < cmd.min || len(args) > cmd.max {
+		return usageError("usage: " + cmd.name + " " + cmd.args)
+	}
+	return cmd.run(args)
+}
+
+var (
+	// conn is the client for the server.
+	conn *client.Client
+
+	// cwd is the directory against which relative paths are resolved.
+	cwd = "/"
+
+	// resources holds the index of mapped resources once it is retrieved.
+	resources []rest.Description
+)
+
+// index returns the descriptions of the resources mapped by the server.
+func index() ([]rest.Description, os.Error) {
+	if resources != nil {
+		return resources, nil
+	}
+	list, err := conn.Index()
+	if err != nil {
+		return nil, err
+	}
+	resources = list
+	return list, nil
+}
+
+// resolve returns the absolute path named by p relative to cwd.  The path of a
+// mapped resource keeps its trailing slash, so that the server does not
+// redirect requests for it.
+func resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(cwd, p)
+	}
+	p = path.Clean(p)
+	list, _ := index()
+	for _, d := range list {
+		if d.Path == p+"/" {
+			return d.Path
+		}
+	}
+	return p
+}
+
+// children returns the names of the resources and members directly below the
+// absolute path p.  The names of those which have members of their own end
+// with a slash.
+func children(p string) []string {
+	dir := p
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	list, _ := index()
+	inside := false
+	for _, d := range list {
+		switch {
+		case d.Path != dir && strings.HasPrefix(d.Path, dir):
+			sub := d.Path[len(dir):]
+			if i := strings.Index(sub, "/"); i >= 0 {
+				sub = sub[:i+1]
+			}
+			add(sub)
+		case strings.HasPrefix(dir, d.Path):
+			inside = true
+		}
+	}
+
+	if inside {
+		var v interface{}
+		if _, err := conn.Get(dir, &v); err == nil {
+			members := []string{}
+			switch v := v.(type) {
+			case map[string]interface{}:
+				for k, elem := range v {
+					members = append(members, k+suffix(elem))
+				}
+				sort.Strings(members)
+			case []interface{}:
+				for i, elem := range v {
+					members = append(members, strconv.Itoa(i)+suffix(elem))
+				}
+			}
+			for _, name := range members {
+				add(name)
+			}
+		}
+	}
+	return names
+}
+
+// suffix returns "/" if the decoded JSON value has members of its own.
+func suffix(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return "/"
+	}
+	return ""
+}
+
+// parseValue returns the value given as an argument: the decoded JSON, the
+// JSON read from standard input if it is "-", or otherwise the argument as a
+// string.
+func parseValue(arg string) (interface{}, os.Error) {
+	data := []byte(arg)
+	if arg == "-" {
+		var err os.Error
+		if data, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return nil, err
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return v, nil
+}
+
+// show prints a decoded value.
+func show(v interface{}) os.Error {
+	if s, ok := v.(string); ok && !*jsonOut {
+		fmt.Println(s)
+		return nil
+	}
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(js))
+	return nil
+}
+
+func ls(args []string) os.Error {
+	p := cwd
+	if len(args) > 0 {
+		p = args[0]
+	}
+	p = resolve(p)
+
+	names := children(p)
+	if len(names) == 0 {
+		// Report why there is nothing, if it is an error
+		var v interface{}
+		if _, err := conn.Get(p, &v); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func get(args []string) os.Error {
+	var v interface{}
+	if _, err := conn.Get(resolve(args[0]), &v); err != nil {
+		return err
+	}
+	return show(v)
+}
+
+func put(args []string) os.Error {
+	v, err := parseValue(args[1])
+	if err != nil {
+		return err
+	}
+	_, err = conn.Put(resolve(args[0]), v)
+	return err
+}
+
+func patch(args []string) os.Error {
+	v, err := parseValue(args[1])
+	if err != nil {
+		return err
+	}
+	_, err = conn.Patch(resolve(args[0]), v)
+	return err
+}
+
+func rm(args []string) os.Error {
+	return conn.Delete(resolve(args[0]))
+}
+
+func watch(args []string) os.Error {
+	w, err := conn.Watch(resolve(args[0]), "")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for {
+		var v interface{}
+		if _, err := w.Next(&v); err == os.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := show(v); err != nil {
+			return err
+		}
+	}
+
+	panic("unreachable")
+}
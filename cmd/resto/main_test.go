@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kylelemons/go-resto/rest"
+)
+
+var splitTests = []struct {
+	Line   string
+	N      int
+	Fields []string
+}{
+	{"", 3, nil},
+	{"ls", 2, []string{"ls"}},
+	{"  get   /config/name ", 2, []string{"get", "/config/name"}},
+	{`put name {"a": 1, "b": 2}`, 3, []string{"put", "name", `{"a": 1, "b": 2}`}},
+	{"put name two words", 3, []string{"put", "name", "two words"}},
+}
+
+func TestSplit(t *testing.T) {
+	for _, test := range splitTests {
+		if got, want := split(test.Line, test.N), test.Fields; !reflect.DeepEqual(got, want) {
+			t.Errorf("split(%q, %d) = %q, want %q", test.Line, test.N, got, want)
+		}
+	}
+}
+
+var exitTests = []struct {
+	Err    os.Error
+	Status int
+}{
+	{nil, 0},
+	{usageError("bad"), 2},
+	{&rest.BadSub{}, 4},
+	{&rest.Timeout{}, 5},
+	{&rest.FailedEncode{}, 5},
+	{&rest.Problem{Status: 409}, 4},
+	{os.NewError("connection refused"), 1},
+}
+
+func TestExitStatus(t *testing.T) {
+	for _, test := range exitTests {
+		if got, want := exitStatus(test.Err), test.Status; got != want {
+			t.Errorf("exitStatus(%#v) = %d, want %d", test.Err, got, want)
+		}
+	}
+}
+
+var prefixTests = []struct {
+	List   []string
+	Prefix string
+}{
+	{nil, ""},
+	{[]string{"config/"}, "config/"},
+	{[]string{"ports/", "patch", "put"}, "p"},
+	{[]string{"name", "tags/"}, ""},
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, test := range prefixTests {
+		if got, want := commonPrefix(test.List), test.Prefix; got != want {
+			t.Errorf("commonPrefix(%q) = %q, want %q", test.List, got, want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"exec"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// shell reads commands from standard input until it ends or the exit command
+// is given.  Errors are printed rather than ending the shell.  If standard
+// input is a terminal, lines are edited in place so that the tab key can
+// complete them.
+func shell(args []string) os.Error {
+	lr := &lineReader{in: bufio.NewReader(os.Stdin)}
+	lr.raw = stty("-icanon", "-echo", "-isig", "min", "1") == nil
+	if lr.raw {
+		defer stty("icanon", "echo", "isig")
+	}
+
+	for {
+		line, err := lr.readLine("resto:" + cwd + "> ")
+		if err == os.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch name := fields[0]; name {
+		case "exit", "quit":
+			return nil
+		case "help":
+			for _, cmd := range commands {
+				if cmd.name != "shell" {
+					fmt.Printf("  %-20s%s\n", cmd.name+" "+cmd.args, cmd.help)
+				}
+			}
+			fmt.Printf("  %-20s%s\n", "cd [path]", "change the directory against which paths are resolved")
+			fmt.Printf("  %-20s%s\n", "exit", "leave the shell")
+			continue
+		case "cd":
+			p := "/"
+			if len(fields) > 1 {
+				p = resolve(fields[1])
+			}
+			cwd = p
+			continue
+		case "shell":
+			fmt.Println("resto: already in the shell")
+			continue
+		}
+
+		// Commands run with the terminal restored, so that they can be
+		// interrupted.
+		if lr.raw {
+			stty("icanon", "echo", "isig")
+		}
+		cmd := lookup(fields[0])
+		max := 1
+		if cmd != nil {
+			max += cmd.max
+		}
+		if err := run(split(line, max)); err != nil {
+			fmt.Printf("resto: %s\n", err)
+		}
+		if lr.raw {
+			stty("-icanon", "-echo", "-isig", "min", "1")
+		}
+	}
+
+	panic("unreachable")
+}
+
+// split splits line into at most n fields separated by spaces, the last of
+// which holds the remainder of the line (so that values may contain spaces).
+func split(line string, n int) []string {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" && len(fields) < n-1 {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			break
+		}
+		fields = append(fields, line[:i])
+		line = strings.TrimSpace(line[i:])
+	}
+	if line != "" {
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// stty changes the settings of the terminal on standard input.
+func stty(args ...string) os.Error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// A lineReader reads lines from the user.  If raw is true, the terminal does
+// not echo or edit lines itself, and the lineReader does so instead.
+type lineReader struct {
+	in  *bufio.Reader
+	raw bool
+}
+
+// readLine prints the prompt and reads a line.
+func (lr *lineReader) readLine(prompt string) (string, os.Error) {
+	fmt.Print(prompt)
+	if !lr.raw {
+		line, err := lr.in.ReadString('\n')
+		if err == os.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	var buf []byte
+	for {
+		b, err := lr.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+		case '\r', '\n':
+			fmt.Println()
+			return string(buf), nil
+		case 4: // ^D
+			if len(buf) == 0 {
+				fmt.Println()
+				return "", os.EOF
+			}
+		case 3: // ^C
+			fmt.Print("^C\n" + prompt)
+			buf = buf[:0]
+		case 127, 8: // DEL, ^H
+			if len(buf) > 0 {
+				buf = buf[:len(buf)-1]
+				fmt.Print("\b \b")
+			}
+		case 27: // escape sequences, such as arrow keys, are ignored
+			if next, _ := lr.in.ReadByte(); next == '[' {
+				lr.in.ReadByte()
+			}
+		case '\t':
+			line := string(buf)
+			matches := complete(line)
+			common := commonPrefix(matches)
+			word := line[strings.LastIndex(line, " ")+1:]
+			switch {
+			case len(common) > len(word):
+				buf = append(buf, common[len(word):]...)
+				fmt.Print(common[len(word):])
+				if len(matches) == 1 && !strings.HasSuffix(common, "/") {
+					buf = append(buf, ' ')
+					fmt.Print(" ")
+				}
+			case len(matches) > 1:
+				fmt.Println()
+				fmt.Println(strings.Join(matches, "  "))
+				fmt.Print(prompt + string(buf))
+			default:
+				fmt.Print("\a")
+			}
+		default:
+			if b >= ' ' {
+				buf = append(buf, b)
+				os.Stdout.Write([]byte{b})
+			}
+		}
+	}
+
+	panic("unreachable")
+}
+
+// complete returns the possible completions of the last word of line: the
+// names of commands if it is the first word, and otherwise the paths which
+// begin with it.
+func complete(line string) []string {
+	fields := strings.Fields(line)
+	word := line[strings.LastIndex(line, " ")+1:]
+
+	var matches []string
+	if len(fields) == 0 || len(fields) == 1 && word != "" {
+		for _, name := range []string{"cd", "exit", "help"} {
+			if strings.HasPrefix(name, word) {
+				matches = append(matches, name)
+			}
+		}
+		for _, cmd := range commands {
+			if cmd.name != "shell" && strings.HasPrefix(cmd.name, word) {
+				matches = append(matches, cmd.name)
+			}
+		}
+		sort.Strings(matches)
+		return matches
+	}
+
+	dir, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, prefix = word[:i+1], word[i+1:]
+	}
+	base := cwd
+	if dir != "" {
+		base = resolve(dir)
+	}
+	for _, name := range children(base) {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, dir+name)
+		}
+	}
+	return matches
+}
+
+// commonPrefix returns the longest prefix shared by every string in list.
+func commonPrefix(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	prefix := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,318 @@
+package rest
+
+import (
+	"bytes"
+	"http"
+	"json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// BatchPath is the path at which batches of requests are served.
+const BatchPath = IndexPath + "batch"
+
+// A BatchOp is a request in a batch.  The body is sent as JSON (or, for PATCH,
+// as a JSON merge patch).
+type BatchOp struct {
+	Method string
+	Path   string
+	Body   *json.RawMessage `json:",omitempty"`
+}
+
+// An OpResult is the reply to a request in a batch.  Requests which were not
+// made, because an earlier request in the batch failed, have no status.
+type OpResult struct {
+	Status   int              `json:",omitempty"`
+	Location string           `json:",omitempty"`
+	ETag     string           `json:",omitempty"`
+	Body     *json.RawMessage `json:",omitempty"`
+	Problem  *Problem         `json:",omitempty"`
+}
+
+// A BatchResult is the reply to a batch.  If any request failed, none of the
+// changes made by the batch were committed.  If they all succeeded but the
+// changes could not all be saved, Problem says why and Saved lists the paths
+// of the resources whose changes were saved (and kept) all the same; the
+// changes to the others were undone.
+type BatchResult struct {
+	Committed bool
+	Results   []OpResult
+	Saved     []string `json:",omitempty"`
+	Problem   *Problem `json:",omitempty"`
+}
+
+// owner returns the resource mapped at the longest path which is a prefix of
+// path, or nil if there is none.  The registry must be locked.
+func owner(path string) *Resource {
+	var best string
+	var found *Resource
+	for p, h := range registry {
+		if !strings.HasPrefix(path, p) || len(p) <= len(best) {
+			continue
+		}
+		if res, ok := h.(*Resource); ok {
+			best, found = p, res
+		}
+	}
+	return found
+}
+
+// A batchEntry is a resource involved in a batch.  If the batch writes to
+// it, its version and history before the batch and the undo records of the
+// requests made to it are kept, so that they can be restored if it fails, as
+// are the files staged for it when it commits.
+type batchEntry struct {
+	res     *Resource
+	write   bool
+	version int64
+	history []*Version
+	undo    []undo
+	files   []*stagedFile
+}
+
+type batchEntries []*batchEntry
+
+func (b batchEntries) Len() int           { return len(b) }
+func (b batchEntries) Less(i, j int) bool { return b[i].res.path < b[j].res.path }
+func (b batchEntries) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// serveBatch serves a batch of requests to resources, sent as a JSON list of
+// BatchOps, as a single transaction.  Every resource involved is locked (in
+// order of path, so that batches cannot deadlock) and the requests are made in
+// order, each after recording how to undo it.  If they all succeed, and the
+// files of every resource modified are written, each resource gets a single
+// new version; otherwise, the requests are undone in reverse order.
+func serveBatch(w http.ResponseWriter, r *http.Request) os.Error {
+	if r.Method != "POST" {
+		return &BadMethod{BatchPath, r.Method, nil, []string{"OPTIONS", "POST"}}
+	}
+	var ops []BatchOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		return &FailedDecode{err, "application/json", ops}
+	}
+
+	registryLock.RLock()
+	byRes := map[*Resource]*batchEntry{}
+	var entries batchEntries
+	targets := make([]*batchEntry, len(ops))
+	for i := range ops {
+		op := &ops[i]
+		op.Method = strings.ToUpper(op.Method)
+		res := owner(op.Path)
+		if res == nil {
+			registryLock.RUnlock()
+			return &BadQuery{BatchPath, os.NewError("no resource is mapped at " + op.Path)}
+		}
+		be, ok := byRes[res]
+		if !ok {
+			be = &batchEntry{res: res}
+			byRes[res] = be
+			entries = append(entries, be)
+		}
+		if op.Method != "GET" && op.Method != "HEAD" {
+			be.write = true
+		}
+		targets[i] = be
+	}
+	registryLock.RUnlock()
+
+	sort.Sort(entries)
+	for _, be := range entries {
+		res := be.res
+		if !be.write {
+			res.lock.RLock()
+			defer res.lock.RUnlock()
+			continue
+		}
+		if res.ro {
+			return &Unsettable{res.path, res.value.Interface()}
+		}
+		res.lock.Lock()
+		defer res.lock.Unlock()
+		res.held = true
+		defer func() { res.held = false }()
+		be.version, be.history = res.version, res.history
+	}
+
+	result := BatchResult{Results: make([]OpResult, len(ops))}
+	failed := 0
+	for i, op := range ops {
+		out, err := targets[i].serve(op)
+		result.Results[i] = out
+		if err != nil || out.Status >= 400 {
+			failed = out.Status
+			break
+		}
+	}
+
+	if failed != 0 {
+		entries.rollback()
+	} else if saved, err := entries.commit(); err != nil {
+		failed = http.StatusInternalServerError
+		result.Saved = saved
+		result.Problem = newProblem(err, failed, BatchPath)
+	} else {
+		result.Committed = true
+	}
+
+	js, err := json.Marshal(result)
+	if err != nil {
+		return &FailedEncode{err, "application/json", result}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if failed != 0 {
+		w.WriteHeader(failed)
+	}
+	_, err = w.Write(js)
+	return err
+}
+
+// commit gives each resource modified by a successful batch a new version,
+// and saves it.  The files of every resource are written before any of them
+// replaces the old one, so if one cannot be written, the batch is undone.
+// If one cannot replace the old one, the resources before it have already
+// been committed and are kept; the rest are undone.  The paths of the
+// resources committed are returned with the error.
+func (b batchEntries) commit() ([]string, os.Error) {
+	for _, be := range b {
+		if !be.write {
+			continue
+		}
+		be.res.version++
+		files, err := be.res.stage()
+		if err != nil {
+			b.discard()
+			b.rollback()
+			return nil, err
+		}
+		be.files = files
+	}
+
+	var committed []string
+	for i, be := range b {
+		if !be.write {
+			continue
+		}
+		files := be.files
+		be.files = nil
+		if err := be.res.commit(files); err != nil {
+			b.discard()
+			b[i:].rollback()
+			return committed, err
+		}
+		be.res.remember()
+		be.res.changes.notify()
+		committed = append(committed, be.res.path)
+	}
+	return committed, nil
+}
+
+// discard removes the files staged for the resources which have not been
+// committed.
+func (b batchEntries) discard() {
+	for _, be := range b {
+		discardFiles(be.files)
+		be.files = nil
+	}
+}
+
+// rollback undoes the requests made to each resource in a failed batch, in
+// reverse order, and restores its version and history.
+func (b batchEntries) rollback() {
+	for _, be := range b {
+		if !be.write {
+			continue
+		}
+		for i := len(be.undo) - 1; i >= 0; i-- {
+			be.res.revert(be.undo[i])
+		}
+		be.undo = nil
+		be.res.version, be.res.history = be.version, be.history
+	}
+}
+
+// serve makes a request in a batch to the resource, and returns its result
+// and the error with which it failed, if any.
+func (be *batchEntry) serve(op BatchOp) (OpResult, os.Error) {
+	res := be.res
+	var body []byte
+	if op.Body != nil {
+		body = *op.Body
+	}
+	r, err := http.NewRequest(op.Method, op.Path, bytes.NewBuffer(body))
+	if err != nil {
+		return OpResult{Status: http.StatusBadRequest}, err
+	}
+	ctype := "application/json"
+	if op.Method == "PATCH" {
+		ctype = MergePatchType
+	}
+	r.Header.Set("Content-Type", ctype)
+
+	rec := &recorder{header: http.Header{}}
+	switch {
+	case wantsWatch(r):
+		err = &BadQuery{op.Path, os.NewError("a batch cannot watch")}
+	default:
+		err = batchCheck(res, r)
+		if err == nil && modifies(r) {
+			if u, ok := res.saveUndo(r.Method, batchPath(res, r)); ok {
+				be.undo = append(be.undo, u)
+			}
+		}
+		if err == nil {
+			err = res.ServeREST(rec, r)
+		}
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if ec, ok := err.(ErrorCoder); ok {
+			status = ec.ErrorCode()
+		}
+		return OpResult{Status: status, Problem: newProblem(err, status, op.Path)}, err
+	}
+
+	out := OpResult{
+		Status:   rec.status,
+		Location: rec.header.Get("Location"),
+		ETag:     rec.header.Get("ETag"),
+	}
+	if out.Status == 0 {
+		out.Status = http.StatusOK
+	}
+	if rec.body.Len() > 0 && strings.HasSuffix(rec.header.Get("Content-Type"), "json") {
+		raw := json.RawMessage(rec.body.Bytes())
+		out.Body = &raw
+	}
+	return out, nil
+}
+
+// batchCheck returns an error if the request cannot be part of a batch:
+// sending on or receiving from a channel, calling a method or function and
+// setting a computed property cannot be undone if the batch fails.  (A
+// receive would also wait with every resource in the batch locked.)
+func batchCheck(res *Resource, r *http.Request) os.Error {
+	path := batchPath(res, r)
+	ent, err := res.resolve(path, false)
+	if err == nil && ent.value.Kind() == reflect.Chan {
+		return &BadQuery{ent.path, os.NewError("channels cannot be part of a batch")}
+	}
+	if !modifies(r) {
+		return nil
+	}
+	if err == nil && ent.irreversible() {
+		return &BadQuery{ent.path, os.NewError("cannot be modified in a batch")}
+	}
+	if res.viaProperty(path) {
+		return &BadQuery{subPath(res.path, path), os.NewError("cannot be modified in a batch")}
+	}
+	return nil
+}
+
+// batchPath returns the path of the entity below the resource to which the
+// request is made.
+func batchPath(res *Resource, r *http.Request) string {
+	return strings.TrimRight(r.URL.Path[len(res.path):], "/")
+}
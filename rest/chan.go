@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"http"
+	"json"
+	"os"
+	"reflect"
+	"time"
+)
+
+// ChanTimeout is the time (in nanoseconds) for which a POST to a channel waits
+// for the value to be sent, and for which a GET of a single value from a
+// channel waits for one to be received.
+var ChanTimeout int64 = 5e9
+
+// Heartbeat is the interval (in nanoseconds) at which an idle stream of values
+// from a channel is written to, so that disconnected clients are noticed.
+var Heartbeat int64 = 15e9
+
+// chanPoll is the interval (in nanoseconds) at which a channel is polled.
+var chanPoll int64 = 10e6
+
+// streamTypes are the media types in which values received from a channel
+// can be sent.  The first sends a single value, the others stream values as
+// newline-delimited JSON or as server-sent events.
+var streamTypes = ParseMediaTypes([]string{
+	"application/json, application/x-ndjson, text/event-stream",
+})
+
+// chanMethods returns the methods allowed on a channel of type t.
+func chanMethods(t reflect.Type, ro bool) []string {
+	allow := []string{"OPTIONS"}
+	if t.ChanDir()&reflect.RecvDir != 0 {
+		allow = append(allow, "HEAD", "GET")
+	}
+	if t.ChanDir()&reflect.SendDir != 0 && !ro {
+		allow = append(allow, "POST")
+	}
+	return allow
+}
+
+// send decodes the request body and sends it on the channel, waiting at most
+// ChanTimeout for it to be accepted.
+func (ent *entity) send(w http.ResponseWriter, r *http.Request) (err os.Error) {
+	ch := ent.value
+	val, err := decode(r, reflect.Zero(ch.Type().Elem()))
+	if err != nil {
+		return err
+	}
+	if err := validate(ent.path, val); err != nil {
+		return err
+	}
+
+	// The resource doesn't need to stay locked while we wait
+	unlock(w)
+
+	defer func() {
+		if recover() != nil {
+			err = &Closed{ent.path, ch.Interface()}
+		}
+	}()
+
+	for deadline := time.Nanoseconds() + ChanTimeout; !ch.TrySend(val); {
+		if time.Nanoseconds() > deadline {
+			return &Timeout{ent.path, ch.Interface()}
+		}
+		time.Sleep(chanPoll)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// recv receives from the channel.  If the client accepts a streaming media
+// type, values are streamed until the channel is closed or the client goes
+// away; otherwise, a single value is sent.  If no value arrives within
+// ChanTimeout, the reply is a 204 No Content.
+func (ent *entity) recv(w http.ResponseWriter, r *http.Request) os.Error {
+	ch := ent.value
+
+	// A single value is sent in any media type with a codec
+	ctype := ""
+	if accept := r.Header["Accept"]; len(accept) > 0 {
+		if mt := streamTypes.Choose(ParseMediaTypes(accept)); mt != nil {
+			ctype = mt.Type + "/" + mt.SubType
+		}
+	}
+	stream := ctype != "" && ctype != "application/json"
+	if !stream {
+		mt := Negotiate(r.Header["Accept"])
+		if mt == nil {
+			return &NotAcceptable{ent.path, r.Header["Accept"]}
+		}
+		ctype = mt.Type + "/" + mt.SubType
+	}
+	w.Header().Set("Content-Type", ctype)
+
+	if r.Method == "HEAD" {
+		return nil
+	}
+
+	// The resource doesn't need to stay locked while we wait
+	unlock(w)
+
+	if !stream {
+		val, ok := ent.poll(ChanTimeout, nil)
+		switch {
+		case !val.IsValid():
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		case !ok:
+			return &Closed{ent.path, ch.Interface()}
+		}
+
+		js, err := LookupCodec(ctype).Encode(val, ent.path)
+		if err != nil {
+			return &FailedEncode{err, ctype, val.Interface()}
+		}
+		if _, err := w.Write(js); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	prefix, suffix, idle := "", "\n", "\n"
+	if ctype == "text/event-stream" {
+		prefix, suffix, idle = "data: ", "\n\n", ":\n\n"
+	}
+
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = func() { f.Flush() }
+	}
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	for {
+		var werr os.Error
+		val, ok := ent.poll(Heartbeat, func() bool {
+			_, werr = w.Write([]byte(idle))
+			flush()
+			return werr == nil
+		})
+		if werr != nil || !ok {
+			return nil
+		}
+
+		js, err := json.Marshal(val.Interface())
+		if err != nil {
+			return &FailedEncode{err, ctype, val.Interface()}
+		}
+		if _, err := w.Write([]byte(prefix + string(js) + suffix)); err != nil {
+			return nil
+		}
+		flush()
+	}
+
+	panic("unreachable")
+}
+
+// poll waits for a value to be received from the channel.  If no value arrives
+// within the given timeout, idle is called (if it is not nil) and polling
+// continues if it returns true; otherwise, poll returns an invalid Value.  The
+// boolean is false if the channel has been closed.
+func (ent *entity) poll(timeout int64, idle func() bool) (reflect.Value, bool) {
+	ch := ent.value
+	deadline := time.Nanoseconds() + timeout
+	for {
+		if val, ok := ch.TryRecv(); val.IsValid() {
+			return val, ok
+		}
+		if time.Nanoseconds() > deadline {
+			if idle == nil || !idle() {
+				return reflect.Value{}, true
+			}
+			deadline = time.Nanoseconds() + timeout
+		}
+		time.Sleep(chanPoll)
+	}
+
+	panic("unreachable")
+}
@@ -0,0 +1,215 @@
+// Package client makes requests to the resources mapped by a server using the
+// rest package.
+//
+// Values are sent as JSON and received in the media type the client asks for,
+// using the codecs registered with the rest package.  Errors sent by the
+// server are recovered as the errors of the rest package (such as
+// *rest.BadSub) which caused them.
+//
+// A Mirror keeps a local copy of a remote value up to date by watching it for
+// changes.
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kylelemons/go-resto/rest"
+)
+
+// A Client makes requests to the resources mapped by a server.
+type Client struct {
+	// URL is the base URL of the server (such as "http://localhost:8080"),
+	// to which paths are appended.
+	URL string
+
+	// MediaType is the media type in which values are requested, which must
+	// have a codec registered with the rest package.  If it is empty,
+	// application/json is used.
+	MediaType string
+
+	// HTTP is the client with which requests are made.  If it is nil,
+	// http.DefaultClient is used.
+	HTTP *http.Client
+
+	// ifMatch is the entity tag on which writes are conditional.
+	ifMatch string
+}
+
+// New returns a client for the server at the given base URL.
+func New(url string) *Client {
+	return &Client{URL: strings.TrimRight(url, "/")}
+}
+
+// IfMatch returns a copy of the client whose writes only succeed if the value
+// being written still has the given entity tag (as returned by Get or by a
+// previous write).  If it does not, they fail with *rest.PreconditionFailed.
+func (c *Client) IfMatch(etag string) *Client {
+	cp := *c
+	cp.ifMatch = etag
+	return &cp
+}
+
+// Index retrieves the descriptions of the resources mapped by the server.
+func (c *Client) Index() ([]rest.Description, os.Error) {
+	var list []rest.Description
+	if _, err := c.Get(rest.IndexPath, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Get retrieves the value at path into v, which must be a pointer, and
+// returns its entity tag.
+func (c *Client) Get(path string, v interface{}) (etag string, err os.Error) {
+	resp, err := c.do("GET", path, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if err := decode(resp, v); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// Put replaces the value at path with v and returns its new entity tag.
+// Structures are updated in place by the server, so only the fields which v
+// encodes are changed.
+func (c *Client) Put(path string, v interface{}) (etag string, err os.Error) {
+	resp, err := c.do("PUT", path, v, "application/json")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Patch merges v into the value at path as a JSON merge patch, and returns
+// its new entity tag.  Members of v (typically a map) which are nil remove
+// the corresponding members of the value.
+func (c *Client) Patch(path string, v interface{}) (etag string, err os.Error) {
+	resp, err := c.do("PATCH", path, v, rest.MergePatchType)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Post sends v (if it is not nil) to the collection, channel or method at
+// path.  If the reply has a body, such as the results of a method, it is
+// decoded into result (if it is not nil).  The location of a newly created
+// element is returned.
+func (c *Client) Post(path string, v, result interface{}) (location string, err os.Error) {
+	resp, err := c.do("POST", path, v, "application/json")
+	if err != nil {
+		return "", err
+	}
+	if result != nil && resp.StatusCode == http.StatusOK {
+		if err := decode(resp, result); err != nil {
+			return "", err
+		}
+	} else {
+		resp.Body.Close()
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+// Delete removes the element at path.
+func (c *Client) Delete(path string) os.Error {
+	resp, err := c.do("DELETE", path, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do makes a request, with v encoded as the body if it is not nil, and
+// returns the response or the error sent by the server.
+func (c *Client) do(method, path string, v interface{}, ctype string) (*http.Response, os.Error) {
+	var body io.Reader
+	if v != nil {
+		js, err := rest.LookupCodec("application/json").Encode(reflect.ValueOf(v), path)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(js)
+	}
+
+	req, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	accept := c.MediaType
+	if accept == "" {
+		accept = "application/json"
+	}
+	req.Header.Set("Accept", accept+", "+rest.ProblemType)
+	if v != nil {
+		req.Header.Set("Content-Type", ctype)
+	}
+	if c.ifMatch != "" {
+		req.Header.Set("If-Match", c.ifMatch)
+	}
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, problem(resp)
+	}
+	return resp, nil
+}
+
+// problem returns the error described by an error response.  Responses which
+// are not problem details are returned as a *rest.Problem with the body as
+// its detail.
+func problem(resp *http.Response) os.Error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	mt := rest.ParseMediaTypes([]string{resp.Header.Get("Content-Type")})
+	if len(mt) > 0 && mt[0].Type+"/"+mt[0].SubType == rest.ProblemType {
+		p := new(rest.Problem)
+		if err := rest.LookupCodec("application/json").Decode(data, p); err == nil {
+			return p.Err()
+		}
+	}
+	return &rest.Problem{
+		Title:  http.StatusText(resp.StatusCode),
+		Status: resp.StatusCode,
+		Detail: strings.TrimSpace(string(data)),
+	}
+}
+
+// decode decodes the body of a response into v with the codec registered for
+// its media type.
+func decode(resp *http.Response, v interface{}) os.Error {
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	ctype := resp.Header.Get("Content-Type")
+	codec := rest.LookupCodec(ctype)
+	if codec == nil {
+		return fmt.Errorf("client: cannot decode %q", ctype)
+	}
+	return codec.Decode(data, v)
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/go-resto/rest"
+)
+
+type testConfig struct {
+	Name  string
+	Ports map[string]int
+	Tags  []string
+}
+
+var testObject = testConfig{
+	Name:  "test",
+	Ports: map[string]int{"http": 80, "https": 443},
+	Tags:  []string{"a", "b"},
+}
+
+func TestClient(t *testing.T) {
+	if _, err := rest.Map("/config", &testObject); err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	srv := httptest.NewServer(rest.DefaultServeMux)
+	defer srv.Close()
+	c := New(srv.URL + "/")
+
+	var cfg testConfig
+	tag, err := c.Get("/config/", &cfg)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if got, want := cfg, testObject; !reflect.DeepEqual(got, want) {
+		t.Errorf("get = %#v, want %#v", got, want)
+	}
+	if tag == "" {
+		t.Errorf("get - no etag")
+	}
+
+	newtag, err := c.IfMatch(tag).Put("/config/name", "renamed")
+	if err != nil {
+		t.Errorf("put: %s", err)
+	}
+	if _, err := c.IfMatch(tag).Put("/config/name", "stale"); err == nil {
+		t.Errorf("put with stale etag succeeded")
+	} else if _, ok := err.(*rest.PreconditionFailed); !ok {
+		t.Errorf("put with stale etag - err = %#v, want PreconditionFailed", err)
+	}
+	if _, err := c.IfMatch(newtag).Put("/config/name", "again"); err != nil {
+		t.Errorf("put with new etag: %s", err)
+	}
+
+	patch := map[string]interface{}{"http": nil, "https": 8443}
+	if _, err := c.Patch("/config/ports", patch); err != nil {
+		t.Errorf("patch: %s", err)
+	}
+
+	loc, err := c.Post("/config/tags", "c", nil)
+	if err != nil {
+		t.Errorf("post: %s", err)
+	}
+	if got, want := loc, "/config/tags/2"; got != want {
+		t.Errorf("post - location = %q, want %q", got, want)
+	}
+	if err := c.Delete("/config/tags/0"); err != nil {
+		t.Errorf("delete: %s", err)
+	}
+
+	cfg = testConfig{}
+	if _, err := c.Get("/config/", &cfg); err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	want := testConfig{
+		Name:  "again",
+		Ports: map[string]int{"https": 8443},
+		Tags:  []string{"b", "c"},
+	}
+	if got := cfg; !reflect.DeepEqual(got, want) {
+		t.Errorf("get = %#v, want %#v", got, want)
+	}
+
+	hal := &Client{URL: srv.URL, MediaType: "application/hal+json"}
+	var tags []string
+	if _, err := hal.Get("/config/tags", &tags); err != nil {
+		t.Errorf("get hal: %s", err)
+	}
+	if got, want := tags, []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("get hal = %v, want %v", got, want)
+	}
+}
+
+var errorTests = []struct {
+	Method string
+	Path   string
+	Error  interface{}
+}{
+	{"GET", "/config/missing", &rest.BadSub{}},
+	{"DELETE", "/config/", &rest.BadMethod{}},
+	{"PUT", "/config/ports", &rest.SchemaError{}},
+	{"GET", "/elsewhere", &rest.Problem{}},
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(rest.DefaultServeMux)
+	defer srv.Close()
+	c := New(srv.URL)
+
+	for _, test := range errorTests {
+		var err os.Error
+		switch test.Method {
+		case "GET":
+			var v interface{}
+			_, err = c.Get(test.Path, &v)
+		case "PUT":
+			_, err = c.Put(test.Path, []int{1})
+		case "DELETE":
+			err = c.Delete(test.Path)
+		}
+		if got, want := reflect.TypeOf(err), reflect.TypeOf(test.Error); got != want {
+			t.Errorf("%s %s - err = %#v, want a %v", test.Method, test.Path, err, want)
+		}
+	}
+
+	err := c.Delete("/config/")
+	if bm, ok := err.(*rest.BadMethod); !ok || len(bm.AllowedMethods()) == 0 {
+		t.Errorf("delete - err = %#v, want a BadMethod with the allowed methods", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	srv := httptest.NewServer(rest.DefaultServeMux)
+	defer srv.Close()
+	c := New(srv.URL)
+
+	var cfg testConfig
+	m, err := c.Mirror("/config/", &cfg)
+	if err != nil {
+		t.Fatalf("mirror: %s", err)
+	}
+	defer m.Close()
+
+	m.RLock()
+	name := cfg.Name
+	m.RUnlock()
+	if got, want := name, "again"; got != want {
+		t.Errorf("name = %q, want %q", got, want)
+	}
+
+	updated := m.Updated()
+	if _, err := c.Put("/config/name", "mirrored"); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	select {
+	case <-updated:
+	case <-time.After(5e9):
+		t.Fatalf("mirror not updated")
+	}
+
+	m.RLock()
+	name = cfg.Name
+	m.RUnlock()
+	if got, want := name, "mirrored"; got != want {
+		t.Errorf("name = %q, want %q", got, want)
+	}
+	if got, want := m.ETag(), etagOf(t, c, "/config/"); got != want {
+		t.Errorf("etag = %s, want %s", got, want)
+	}
+}
+
+type testCounter struct {
+	N int
+}
+
+var testCounterObject = testCounter{N: 1}
+
+func TestMirrorProperty(t *testing.T) {
+	res, err := rest.Map("/counter", &testCounterObject)
+	if err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	double := func() int { return 2 * testCounterObject.N }
+	if err := res.Property("double", double, nil); err != nil {
+		t.Fatalf("property: %s", err)
+	}
+	srv := httptest.NewServer(rest.DefaultServeMux)
+	defer srv.Close()
+	c := New(srv.URL)
+
+	var v map[string]int
+	m, err := c.Mirror("/counter/", &v)
+	if err != nil {
+		t.Fatalf("mirror: %s", err)
+	}
+	defer m.Close()
+
+	updated := m.Updated()
+	if _, err := c.Put("/counter/n", 5); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	select {
+	case <-updated:
+	case <-time.After(5e9):
+		t.Fatalf("mirror not updated")
+	}
+
+	m.RLock()
+	got := v["double"]
+	m.RUnlock()
+	if want := 10; got != want {
+		t.Errorf("double = %d, want %d", got, want)
+	}
+	if got, want := m.ETag(), etagOf(t, c, "/counter/"); got != want {
+		t.Errorf("etag = %s, want %s", got, want)
+	}
+}
+
+func etagOf(t *testing.T, c *Client, path string) string {
+	var v interface{}
+	etag, err := c.Get(path, &v)
+	if err != nil {
+		t.Fatalf("get %s: %s", path, err)
+	}
+	return etag
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// MirrorRetry is the time (in nanoseconds) for which a Mirror waits before
+// reconnecting to a server after its stream of changes ends.
+var MirrorRetry int64 = 1e9
+
+// A Mirror keeps a local value in sync with a value served by a Resource.
+// The local value is only modified while the Mirror is locked for writing, so
+// it should be read while holding a read lock:
+//
+//	m.RLock()
+//	port := cfg.Port
+//	m.RUnlock()
+type Mirror struct {
+	sync.RWMutex
+
+	client *Client
+	path   string
+	value  reflect.Value
+
+	// state holds the fields below, which the Mirror updates as it runs.
+	state  sync.Mutex
+	etag   string
+	err    os.Error
+	watch  *Watch
+	closed bool
+	update chan bool
+}
+
+// Mirror retrieves the value at path into v, which must be a pointer, and
+// returns a Mirror which keeps it up to date as the value changes on the
+// server.  If the stream of changes ends, the Mirror reconnects after
+// MirrorRetry, and only receives the value again if it changed meanwhile.
+func (c *Client) Mirror(path string, v interface{}) (*Mirror, os.Error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return nil, os.NewError("client: Mirror requires a non-nil pointer")
+	}
+
+	m := &Mirror{
+		client: c,
+		path:   path,
+		value:  val.Elem(),
+		update: make(chan bool),
+	}
+	etag, err := c.Get(path, v)
+	if err != nil {
+		return nil, err
+	}
+	m.etag = etag
+
+	go m.run()
+	return m, nil
+}
+
+// ETag returns the entity tag of the local value.
+func (m *Mirror) ETag() string {
+	m.state.Lock()
+	defer m.state.Unlock()
+	return m.etag
+}
+
+// Err returns the error with which the stream of changes last ended, or nil.
+func (m *Mirror) Err() os.Error {
+	m.state.Lock()
+	defer m.state.Unlock()
+	return m.err
+}
+
+// Updated returns a channel which is closed when the local value is next
+// updated.
+func (m *Mirror) Updated() <-chan bool {
+	m.state.Lock()
+	defer m.state.Unlock()
+	return m.update
+}
+
+// Close stops updating the local value.
+func (m *Mirror) Close() {
+	m.state.Lock()
+	defer m.state.Unlock()
+	m.closed = true
+	if m.watch != nil {
+		m.watch.Close()
+	}
+}
+
+// run follows the stream of changes until the Mirror is closed, reconnecting
+// whenever it ends.
+func (m *Mirror) run() {
+	for {
+		err := m.follow()
+
+		m.state.Lock()
+		closed := m.closed
+		m.err, m.watch = err, nil
+		m.state.Unlock()
+		if closed {
+			return
+		}
+		time.Sleep(MirrorRetry)
+	}
+}
+
+// follow watches the value on the server and stores each new value sent in
+// the stream, until the stream ends.
+func (m *Mirror) follow() os.Error {
+	w, err := m.client.Watch(m.path, m.ETag())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	m.state.Lock()
+	if m.closed {
+		m.state.Unlock()
+		return nil
+	}
+	m.watch = w
+	m.state.Unlock()
+
+	for {
+		val := reflect.New(m.value.Type())
+		etag, err := w.Next(val.Interface())
+		if err == os.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		m.store(etag, val.Elem())
+	}
+
+	panic("unreachable")
+}
+
+// store replaces the local value with a new value.
+func (m *Mirror) store(etag string, val reflect.Value) {
+	m.Lock()
+	m.value.Set(val)
+	m.Unlock()
+
+	m.state.Lock()
+	m.etag = etag
+	close(m.update)
+	m.update = make(chan bool)
+	m.state.Unlock()
+}
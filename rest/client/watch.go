@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bufio"
+	"http"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kylelemons/go-resto/rest"
+)
+
+// A Watch is a stream of the values taken by a value on the server as it
+// changes.
+type Watch struct {
+	body io.ReadCloser
+	in   *bufio.Reader
+}
+
+// Watch opens a stream of changes to the value at path.  If etag is the entity
+// tag of the value the caller already has, the value is not sent until it
+// differs from that; otherwise, the current value is sent first.
+func (c *Client) Watch(path, etag string) (*Watch, os.Error) {
+	req, err := http.NewRequest("GET", c.URL+path+"?watch", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", rest.WatchType+", "+rest.ProblemType)
+	if etag != "" {
+		req.Header.Set("Last-Event-ID", etag)
+	}
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, problem(resp)
+	}
+	return &Watch{resp.Body, bufio.NewReader(resp.Body)}, nil
+}
+
+// Next waits for the next value in the stream, decodes it into v (which must
+// be a pointer) and returns its entity tag.  It returns os.EOF when the stream
+// ends.
+func (w *Watch) Next(v interface{}) (etag string, err os.Error) {
+	var id, data string
+	for {
+		line, err := w.in.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && data != "":
+			if err := rest.LookupCodec("application/json").Decode([]byte(data), v); err != nil {
+				return "", err
+			}
+			return id, nil
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(line[len("id:"):])
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(line[len("data:"):])
+		}
+	}
+
+	panic("unreachable")
+}
+
+// Close closes the stream, so that a pending call to Next returns.
+func (w *Watch) Close() os.Error {
+	return w.body.Close()
+}
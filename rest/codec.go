@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"bytes"
+	"json"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// A Codec converts values to and from the representation of a media type.
+type Codec interface {
+	// Encode returns the representation of v, which is found at path.
+	Encode(v reflect.Value, path string) ([]byte, os.Error)
+
+	// Decode parses a representation into the value to which v points.
+	Decode(data []byte, v interface{}) os.Error
+}
+
+var (
+	codecs = map[string]Codec{
+		"application/json":     jsonCodec{},
+		"application/hal+json": halCodec{},
+	}
+	mediaTypes = ParseMediaTypes([]string{"application/json, application/hal+json"})
+)
+
+// RegisterCodec makes the codec available for the given media type, which must
+// not include parameters.  Media types registered later are less preferred
+// when a client accepts more than one equally.
+func RegisterCodec(mediaType string, c Codec) {
+	mediaType = strings.ToLower(mediaType)
+	if _, ok := codecs[mediaType]; !ok {
+		mt := ParseMediaTypes([]string{mediaType})[0]
+		mt.Index = len(mediaTypes)
+		mediaTypes = append(mediaTypes, mt)
+	}
+	codecs[mediaType] = c
+}
+
+// LookupCodec returns the codec registered for the given media type, ignoring
+// any parameters, or nil if there is none.
+func LookupCodec(mediaType string) Codec {
+	mediaType = strings.ToLower(strings.TrimSpace(strings.Split(mediaType, ";")[0]))
+	return codecs[mediaType]
+}
+
+// Negotiate returns the media type with a registered codec which best matches
+// the given Accept header values, or nil if none does.  If there are no values,
+// the most preferred media type (application/json) is returned.
+func Negotiate(accept []string) *MediaType {
+	if len(accept) == 0 {
+		return mediaTypes[0].Copy()
+	}
+	return mediaTypes.Choose(ParseMediaTypes(accept))
+}
+
+// jsonCodec represents values as plain JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v reflect.Value, path string) ([]byte, os.Error) {
+	if v = indirect(v); v.Kind() == reflect.Map {
+		return json.Marshal(stringKeys(v))
+	}
+	return json.Marshal(v.Interface())
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) os.Error {
+	return json.Unmarshal(data, v)
+}
+
+// halCodec represents values in the JSON Hypertext Application Language, in
+// which every structure, map and slice is an object with a _links member.
+// Slices are objects whose elements are embedded as "item".
+type halCodec struct{}
+
+func (halCodec) Encode(v reflect.Value, path string) ([]byte, os.Error) {
+	e := &encoder{
+		buf:   bytes.NewBuffer(nil),
+		depth: -1,
+		hal:   true,
+	}
+	if err := e.encode(v, path, nil, 0); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+func (halCodec) Decode(data []byte, v interface{}) os.Error {
+	switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+	case reflect.Slice, reflect.Array:
+		var obj struct {
+			Embedded struct {
+				Item json.RawMessage `json:"item"`
+			} `json:"_embedded"`
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		if data = obj.Embedded.Item; data == nil {
+			data = []byte("null")
+		}
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		obj["_links"] = nil, false
+		js, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		data = js
+	}
+	return json.Unmarshal(data, v)
+}
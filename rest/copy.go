@@ -0,0 +1,123 @@
+package rest
+
+import (
+	"reflect"
+)
+
+// clone returns a resource at the same path as res which holds a deep copy of
+// its value.  Properties are not copied, since they would modify the original.
+func (res *Resource) clone() *Resource {
+	return &Resource{
+		path:  res.path,
+		kind:  res.kind,
+		value: deepCopy(res.value),
+	}
+}
+
+// replacement returns the value into which a complete JSON encoding of a new
+// value for val is decoded: a copy of val in which everything that is encoded
+// has been reset, so that the rest (unexported fields, channels, functions and
+// fields which are not encoded) keeps its value.
+func replacement(val reflect.Value) reflect.Value {
+	cp := deepCopy(val)
+	clearEncoded(cp)
+	return cp
+}
+
+// clearEncoded resets the parts of v, which must be settable, that are
+// encoded as JSON.  Structures (including those to which fields point) are
+// reset field by field; fields which are omitted when empty are reset
+// entirely, since their absence from an encoding means they are empty.
+func clearEncoded(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			clearEncoded(v.Elem())
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f, fv := t.Field(i), v.Field(i)
+			key, omitempty := jsonKey(f)
+			switch {
+			case f.PkgPath != "" || key == "":
+			case fv.Kind() == reflect.Chan || fv.Kind() == reflect.Func:
+			case omitempty:
+				fv.Set(reflect.Zero(fv.Type()))
+			default:
+				clearEncoded(fv)
+			}
+		}
+	case reflect.Chan, reflect.Func:
+	default:
+		v.Set(reflect.Zero(v.Type()))
+	}
+}
+
+// deepCopy returns an addressable copy of v which shares no pointers, maps or
+// slices with it, except through unexported fields, functions and channels.
+func deepCopy(v reflect.Value) reflect.Value {
+	out := reflect.New(v.Type()).Elem()
+	copyInto(out, v, map[uintptr]reflect.Value{})
+	return out
+}
+
+// copyInto sets dst to a deep copy of src.  Pointers which have already been
+// copied are in seen, so that the copy has the same shape as the original.
+func copyInto(dst, src reflect.Value, seen map[uintptr]reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		if p, ok := seen[src.Pointer()]; ok {
+			dst.Set(p)
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		seen[src.Pointer()] = p
+		copyInto(p.Elem(), src.Elem(), seen)
+		dst.Set(p)
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		copyInto(elem, src.Elem(), seen)
+		dst.Set(elem)
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		m := reflect.MakeMap(src.Type())
+		for _, key := range src.MapKeys() {
+			elem := reflect.New(src.Type().Elem()).Elem()
+			copyInto(elem, src.MapIndex(key), seen)
+			m.SetMapIndex(key, elem)
+		}
+		dst.Set(m)
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			copyInto(s.Index(i), src.Index(i), seen)
+		}
+		dst.Set(s)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyInto(dst.Index(i), src.Index(i), seen)
+		}
+	case reflect.Struct:
+		// Unexported fields can only be copied with the whole structure
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).PkgPath == "" {
+				copyInto(dst.Field(i), src.Field(i), seen)
+			}
+		}
+	default:
+		dst.Set(src)
+	}
+}
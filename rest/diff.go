@@ -0,0 +1,233 @@
+package rest
+
+import (
+	"http"
+	"json"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// PatchType is the media type of JSON Patches (RFC 6902), in which the
+// differences between values are sent.
+const PatchType = "application/json-patch+json"
+
+// A patchOp is an operation of a JSON Patch.  Its path is a JSON Pointer made
+// of the same path segments which name sub-entities in requests.
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+// diff returns the operations which transform the value a into the value b,
+// both of which are found at the JSON Pointer ptr.  The values are compared
+// by walking them in the same way as requests are resolved, so structures are
+// compared field by field, maps key by key and slices index by index (or, if
+// their elements have key fields, key by key).
+func diff(ptr string, a, b reflect.Value) ([]patchOp, os.Error) {
+	a, b = indirect(a), indirect(b)
+
+	switch {
+	case !a.IsValid() && !b.IsValid():
+		return nil, nil
+	case !a.IsValid():
+		op, err := valueOp("add", ptr, b)
+		return []patchOp{op}, err
+	case !b.IsValid():
+		return []patchOp{{Op: "remove", Path: ptr}}, nil
+	case a.Type() != b.Type() || isNil(a) != isNil(b):
+		return replaceOp(ptr, b)
+	}
+
+	switch a.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil, nil
+	case reflect.Struct:
+		e := &encoder{}
+		am, err := e.structMembers(a, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		bm, err := e.structMembers(b, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		return diffMembers(ptr, am, bm)
+	case reflect.Map:
+		if isNil(a) {
+			return nil, nil
+		}
+		return diffMembers(ptr, mapMembers(a, nil), mapMembers(b, nil))
+	case reflect.Slice, reflect.Array:
+		if isNil(a) {
+			return nil, nil
+		}
+		if field := keyField(a.Type().Elem()); field >= 0 {
+			am, aok := keyedMembers(a, field)
+			bm, bok := keyedMembers(b, field)
+			if aok && bok {
+				return diffMembers(ptr, am, bm)
+			}
+		}
+		return diffElems(ptr, a, b)
+	}
+
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return nil, nil
+	}
+	return replaceOp(ptr, b)
+}
+
+// isNil returns true if v is a nil map, slice, pointer or interface.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// diffMembers returns the operations which transform the members a of a
+// structure or map into the members b.
+func diffMembers(ptr string, a, b []member) ([]patchOp, os.Error) {
+	var ops []patchOp
+	bs := make(map[string]member, len(b))
+	for _, m := range b {
+		bs[m.name] = m
+	}
+	as := make(map[string]bool, len(a))
+	for _, m := range a {
+		as[m.name] = true
+		sub := ptr + "/" + escapePointer(m.name)
+		bm, ok := bs[m.name]
+		if !ok {
+			ops = append(ops, patchOp{Op: "remove", Path: sub})
+			continue
+		}
+		more, err := diff(sub, m.value, bm.value)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, more...)
+	}
+	for _, m := range b {
+		if as[m.name] {
+			continue
+		}
+		op, err := valueOp("add", ptr+"/"+escapePointer(m.name), m.value)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// diffElems returns the operations which transform the slice a into the
+// slice b.  Elements are compared by index; extra elements are appended to
+// a or removed from its end.
+func diffElems(ptr string, a, b reflect.Value) ([]patchOp, os.Error) {
+	var ops []patchOp
+	n := a.Len()
+	if b.Len() < n {
+		n = b.Len()
+	}
+	for i := 0; i < n; i++ {
+		more, err := diff(ptr+"/"+strconv.Itoa(i), a.Index(i), b.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, more...)
+	}
+	for i := a.Len() - 1; i >= n; i-- {
+		ops = append(ops, patchOp{Op: "remove", Path: ptr + "/" + strconv.Itoa(i)})
+	}
+	for i := n; i < b.Len(); i++ {
+		op, err := valueOp("add", ptr+"/-", b.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// keyedMembers returns the elements of the slice or array v, whose elements
+// have the given key field, as members named by their keys.  If an element
+// has no key (because it is a nil pointer) or a key is repeated, it returns
+// false, and the elements must be compared by index instead.
+func keyedMembers(v reflect.Value, field int) ([]member, bool) {
+	members := make([]member, v.Len())
+	seen := make(map[string]bool, v.Len())
+	for i := range members {
+		elem := v.Index(i)
+		k := indirect(elem)
+		if k.Kind() != reflect.Struct {
+			return nil, false
+		}
+		name := formatKey(k.Field(field))
+		if seen[name] {
+			return nil, false
+		}
+		seen[name] = true
+		members[i] = member{name, name, elem, nil}
+	}
+	return members, true
+}
+
+// replaceOp returns the operation which replaces the value at ptr with v.
+func replaceOp(ptr string, v reflect.Value) ([]patchOp, os.Error) {
+	op, err := valueOp("replace", ptr, v)
+	if err != nil {
+		return nil, err
+	}
+	return []patchOp{op}, nil
+}
+
+// valueOp returns an operation which sets the value at ptr to v.
+func valueOp(op, ptr string, v reflect.Value) (patchOp, os.Error) {
+	js, err := jsonCodec{}.Encode(v, ptr)
+	if err != nil {
+		return patchOp{}, &FailedEncode{err, "application/json", v.Interface()}
+	}
+	raw := json.RawMessage(js)
+	return patchOp{Op: op, Path: ptr, Value: &raw}, nil
+}
+
+// wantsDiff returns true if the request asks for the differences between the
+// value it names and its body.
+func wantsDiff(r *http.Request) bool {
+	if r.Method != "POST" {
+		return false
+	}
+	_, ok := r.URL.Query()["diff"]
+	return ok
+}
+
+// serveDiff serves the JSON Patch which would transform the value at path
+// into the value it would hold if the body of the request were PUT there.  The
+// PUT is made to a copy of the resource, so the value is not changed.
+func (res *Resource) serveDiff(w http.ResponseWriter, r *http.Request, path string) os.Error {
+	var cur reflect.Value
+	if ent, err := res.resolve(path, false); err == nil {
+		cur = ent.value
+	} else if _, ok := err.(*BadSub); !ok {
+		return err
+	}
+
+	cp := res.clone()
+	ent, err := cp.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	if err := ent.put(discard{http.Header{}}, r); err != nil {
+		return err
+	}
+
+	// A new element is only found once it has been added
+	if ent, err = cp.resolve(path, false); err != nil {
+		return err
+	}
+	return sendDiff(w, r, cur, ent.value)
+}
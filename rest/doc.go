@@ -5,6 +5,35 @@
 // type or SetReadOnly() is caled on the returned *Resource, the variable will
 // be accessible, but not modifiable.
 //
+// Every resource mapped with Map or Handle is listed in a read-only index at
+// IndexPath ("/_rest/"), which gives its path, Go type, kind, whether it is
+// read-only and the methods it allows.  An OpenAPI document describing the
+// paths below every Resource, derived from the types of their values, is
+// served at OpenAPIPath ("/_rest/openapi.json").
+//
+// A POST to BatchPath ("/_rest/batch") with a JSON list of BatchOps makes
+// each request in turn as a single transaction.  The Resources involved are
+// locked in order of path, and the value of the part of each which a request
+// may modify is copied before it is made.  If every request succeeds, each
+// Resource modified gets one new version, and is saved only once all of them
+// can be; otherwise the copies are restored, nothing is changed and the reply
+// has the status of the failed request.  Either way, the reply is a
+// BatchResult listing the status, headers, body or problem of each request
+// made.  (If a saved file cannot then replace the old one, the Resources
+// already saved are listed in the result and the rest are restored.)
+// Channels cannot be part of a batch, and methods, functions and computed
+// properties cannot be modified in one.
+//
+// The value of a Resource can be saved with Snapshot and loaded with Restore.
+// A Resource on which Persist is called is loaded from the given file, and
+// saved to it after every request which modifies it.  For larger values,
+// Journal records each such request instead, in a journal which is replayed
+// on top of a periodic snapshot when the Resource is next journaled.  The
+// reply to a request is only sent once the change has been saved or
+// journaled; if that fails, the change is undone (unless it was a call to a
+// method or function) and the request fails.  Restoring a value keeps the
+// parts of it which are not encoded, such as unexported fields.
+//
 // Below are the types understood as objects mapped through the REST interface,
 // and what the various methods do when performed on an object of that type. If
 // a method is not described below, it is not suported.
@@ -14,6 +43,65 @@
 //     sent as part of the reply).
 //   OPTIONS requests respond with the acceptable methods for that object in
 //     the response Allow header.
+//   PUT and DELETE requests respond with 204 No Content, or 201 Created if a
+//     PUT creates a new element.
+//   Unsupported methods respond with 405 Method Not Allowed and an Allow
+//     header listing the acceptable methods.
+//   PATCH requests apply the JSON merge patch (application/merge-patch+json)
+//     in the body to the value, and respond with 204 No Content.  The merged
+//     value is checked against the schema of the value before it is stored.
+//   GET, PUT and PATCH replies carry an ETag header derived from the encoded
+//     value.  The tag of a GET reply is that of the representation sent, so
+//     it varies with the media type and query; writes are checked against
+//     the tag of the plain JSON representation.  A GET with a matching
+//     If-None-Match header responds with 304 Not Modified, and a write whose
+//     If-Match header does not match responds with 412 Precondition Failed
+//     and changes nothing.
+//   Errors are sent as text, or as problem details (RFC 7807) if the client
+//     accepts application/problem+json.  The error member names the type of
+//     the error, so that the client package can recover it.
+//   GET requests with a watch query parameter stream the value as server-sent
+//     events: the current value, then the new value whenever a request
+//     modifies the Resource and the value changes.  Each event's id is the
+//     ETag of the value; a client reconnecting with it in Last-Event-ID is
+//     sent the value only if it has changed since.
+//   A Resource on which KeepHistory is called keeps the last few versions of
+//     its value (by default, none are kept; see HistorySize).  GET requests
+//     with a history query parameter list them, and with version=N, return
+//     version N as a GET of it would.  GET requests with diff=N (and
+//     optionally to=M) return the JSON Patch which transforms version N into
+//     the current value (or version M).  A POST with rollback=N replaces the
+//     value with version N, keeping the parts of it which are not encoded.
+//   POST requests with a diff query parameter return the JSON Patch which
+//     would transform the value into the one it would hold if the body were
+//     PUT, without changing it.  They only read the Resource, so they may be
+//     made to read-only Resources.
+//   PUT, PATCH, POST and DELETE requests with dry_run=true, or a "Prefer:
+//     dry-run" header, are served against a copy of the Resource holding
+//     only a read lock.  The reply is the one the request would receive, but
+//     the value, version and history of the Resource are unchanged.  Channels,
+//     methods, functions and computed properties act outside the copy, so
+//     requests to them cannot be dry run and fail with 400 Bad Request.
+//
+//   Nil pointers are served as null.  A PUT to or below a nil pointer or nil
+//     map allocates it; other requests below one respond with 404 Not Found.
+//   GET requests with a fields query parameter, a list of dotted field paths
+//     separated by ",", return only those fields (and map elements).  They
+//     are selected from each element of a slice.
+//   GET requests with a depth query parameter replace structures and
+//     collections nested more deeply than the given depth with an object
+//     whose href member links to them.
+//   GET requests with a schema query parameter, or which accept
+//     application/schema+json, return a JSON Schema for the value.  The
+//     bodies of PUT requests are checked against it before they are decoded,
+//     and are rejected with 400 Bad Request and a list of every violation
+//     (by JSON Pointer) if they do not conform.
+//   GET requests are answered in the media type the Accept header prefers
+//     of those with a registered Codec, or with 406 Not Acceptable if there
+//     is none.  application/json is the default.  In application/hal+json,
+//     each structure, map and slice has a _links member linking to itself
+//     and to each of its children by the paths under which they are mapped,
+//     and the elements of slices are embedded as "item".
 //
 // Basic Types: (int, float, string, etc)
 //   GET requests will return the value (as described below) of the variable.
@@ -22,14 +110,68 @@
 // Collection Types: (map, slice, etc)
 //   GET requests will return all of the values stored in the collection.
 //   PUT will replace the collection with the given set of values.
-//   POST will add a new element to the collection.
-//   - Subelements of a map or slice (by string key or numeric index):
+//   POST will add a new element to the collection.  The reply is a 201 Created
+//     with the Location of the new element.
+//   - GET requests with a filter query parameter return only the elements
+//     which match it.  A filter is a list of conditions separated by ",", at
+//     least one of which must be met, such as "status==running,started>100".
+//     Elements must match every filter given, so
+//     "?filter=status==running&filter=started>100" returns only the running
+//     elements started after 100.  Each condition compares a field of the
+//     element, named by a dotted path, with ==, !=, <, <=, >, >=, ~=
+//     (contains) or ^= (has prefix).  The path "value" names the element
+//     itself, and for maps, "key" names its key.  A literal may be quoted as
+//     a Go string, such as "a,b", to include "," or other reserved characters
+//     (escaped as usual in the query string).
+//   - Slices and arrays:
+//     GET requests with a sort query parameter, a list of dotted field paths
+//     separated by ",", return the elements sorted by those fields, each in
+//     descending order if it is prefixed by "-".  Filtering and sorting happen
+//     before the elements are paged as below.
+//     GET requests with offset and/or limit query parameters, or with a
+//     Range header in items (e.g. "Range: items=100-199"), return only the
+//     requested elements with 206 Partial Content, a Content-Range header
+//     and Link headers to the next and previous pages.
+//   - Subelements of a map or slice (by key or numeric index; map keys may be
+//     strings, numbers, booleans or implement TextUnmarshaler):
 //     GET requests return the value of the element
 //     PUT requests create or replace the element
+//     DELETE requests remove the element
+//   - Negative indices count back from the end of a slice, so -1 names its
+//     last element.  A PUT to the index "-" appends an element.
+//   - Elements of a slice of structures with a field tagged `rest:"key"` may
+//     also be named by the value of that field.  A PUT to a new key appends
+//     an element, and a POST of an element with an existing key fails with
+//     409 Conflict.
+//
+// Channel Types:
+//   GET requests will receive a single value from the channel, or reply with
+//     204 No Content if none arrives within ChanTimeout.  If the client
+//     accepts application/x-ndjson or text/event-stream, values are streamed
+//     until the channel is closed or the client disconnects.
+//   POST requests will send the value to the channel, or fail with 503 Service
+//     Unavailable if it is not accepted within ChanTimeout.
+//   - Receive-only and send-only channels only allow GET and POST respectively.
 //
 // Object Types: (interfaces, structs, etc)
 //   GET requests will return the entire value
 //   PUT will modify the corresponding parts of the value
 //   - Fields of a structure are mapped below the object in the same way
 //     they would be if the field were Mapped directly.
+//   - Exported methods of any value, and fields holding functions, are mapped
+//     below the value by name (without regard to case).  A GET calls a getter
+//     (a method that takes no arguments and returns something other than an
+//     os.Error); a POST calls any method with the arguments in the body (as a
+//     JSON array if there is more than one).  The results are sent in the
+//     same way, in the media type the Accept header prefers.  If the last
+//     result is a non-nil os.Error, it is sent as the error for the request
+//     instead.  Methods promoted from embedded fields are not mapped, but
+//     methods which shadow them are.
+//   - Computed properties added to a Resource with Property are included in
+//     the encoding of its root object and mapped below it like fields.
+//   - Values which implement Validator are validated before they are stored.
+//   - Interfaces are served according to their dynamic value.  A PUT to an
+//     interface decodes a new value of the same dynamic type, or of the type
+//     registered with RegisterType under the name in the TypeField member of
+//     the body.
 package rest
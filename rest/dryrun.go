@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// isDryRun returns true if the request would modify the resource but asks
+// only for the reply it would receive, with a dry_run query parameter or a
+// "Prefer: dry-run" header.  A dry_run parameter which is not a boolean also
+// counts, so that the request is never made for real; dryRun rejects it.
+func isDryRun(r *http.Request) bool {
+	if !modifies(r) {
+		return false
+	}
+	if v, ok := r.URL.Query()["dry_run"]; ok {
+		if len(v) == 0 || v[0] == "" {
+			return true
+		}
+		dry, err := strconv.Atob(v[0])
+		return err != nil || dry
+	}
+	return prefersDryRun(r)
+}
+
+// prefersDryRun returns true if the request has a "Prefer: dry-run" header.
+func prefersDryRun(r *http.Request) bool {
+	for _, h := range r.Header["Prefer"] {
+		for _, pref := range strings.Split(h, ",") {
+			token := strings.TrimSpace(strings.Split(pref, ";")[0])
+			if strings.ToLower(token) == "dry-run" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dryRun serves a request which would modify the resource against a copy of
+// it, so that the reply (including its status and any results) is the one the
+// request would receive, but the resource, its version and its history are
+// unchanged.  Channels, methods and functions may act outside the copy, and
+// computed properties are not copied, so requests to them cannot be dry runs.
+func (res *Resource) dryRun(w http.ResponseWriter, r *http.Request, path string) os.Error {
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		if _, err := strconv.Atob(v); err != nil {
+			return &BadQuery{subPath(res.path, path), os.NewError("invalid dry_run " + strconv.Quote(v))}
+		}
+	}
+	if ent, err := res.resolve(path, false); err == nil && ent.irreversible() {
+		return &BadQuery{ent.path, os.NewError("dry runs cannot send on channels or call functions")}
+	}
+	if res.viaProperty(path) {
+		return &BadQuery{subPath(res.path, path), os.NewError("dry runs cannot modify computed properties")}
+	}
+
+	cp := res.clone()
+	cp.ro = res.ro
+	cp.version, cp.history = res.version, res.history
+	if prefersDryRun(r) {
+		w.Header().Set("Preference-Applied", "dry-run")
+	}
+	return cp.serve(w, r, path)
+}
+
+// irreversible returns true if modifying the entity may act outside the value
+// of the resource, so that it cannot be done to a copy or undone: it is a
+// channel, a method or a function.  (Methods may change state which a copy
+// shares with the value, such as unexported maps.)
+func (ent *entity) irreversible() bool {
+	k := ent.value.Kind()
+	return k == reflect.Chan || k == reflect.Func
+}
+
+// viaProperty returns true if path names a computed property of the resource
+// or something below one.
+func (res *Resource) viaProperty(path string) bool {
+	if idx := strings.IndexRune(path, '/'); idx >= 0 {
+		path = path[:idx]
+	}
+	return path != "" && lookup(res.props, path) != nil
+}
@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"http"
+	"io/ioutil"
+	"json"
+	"os"
+	"reflect"
+)
+
+// TypeField is the name of the member of a JSON object which selects the
+// registered type to decode into when the object is written to an interface.
+var TypeField = "_type"
+
+var types = map[string]reflect.Type{}
+
+// RegisterType makes the type of example available under the given name for
+// decoding into interface values.  When the body of a PUT to an interface
+// contains a TypeField member with the given name, a new value of the same
+// type as example (which may be a pointer) is decoded and stored in the
+// interface.  RegisterType should be called during initialization.
+func RegisterType(name string, example interface{}) {
+	types[name] = reflect.TypeOf(example)
+}
+
+// decodeDynamic reads a JSON request body into a new value which can be
+// stored in the entity's interface.  The type of the new value is selected by
+// the TypeField member of the body if it is present; otherwise, it is the same
+// as the current dynamic type, and a structure is updated in place as for any
+// other PUT.
+func (ent *entity) decodeDynamic(r *http.Request) (reflect.Value, os.Error) {
+	ctype := "application/json"
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	it := ent.iface.Type()
+	var t reflect.Type
+
+	var probe map[string]interface{}
+	if json.Unmarshal(body, &probe) == nil {
+		if name, ok := probe[TypeField].(string); ok {
+			if t, ok = types[name]; !ok || !t.Implements(it) {
+				return reflect.Value{}, &UnknownType{ent.path, name, it}
+			}
+		}
+	}
+
+	var cur reflect.Value
+	if !ent.iface.IsNil() {
+		cur = ent.iface.Elem()
+		if t == nil {
+			t = cur.Type()
+		}
+	}
+	if t == nil {
+		return reflect.Value{}, &UnknownType{ent.path, "", it}
+	}
+
+	// Decode into the value referred to by a pointer type
+	base := t
+	if base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	ptr := reflect.New(base)
+	if cur.IsValid() && cur.Type() == t && base.Kind() == reflect.Struct {
+		if v := indirect(cur); v.Type() == base {
+			ptr.Elem().Set(v)
+		}
+	}
+	if err := json.Unmarshal(body, ptr.Interface()); err != nil {
+		return reflect.Value{}, &FailedDecode{err, ctype, ptr.Elem().Interface()}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return ptr, nil
+	}
+	return ptr.Elem(), nil
+}
+
+// setDynamic stores val in the entity's interface.
+func (ent *entity) setDynamic(val reflect.Value) os.Error {
+	if err := validate(ent.path, val); err != nil {
+		return err
+	}
+
+	switch {
+	case ent.iface.CanSet():
+		ent.iface.Set(val)
+	case ent.parent.Kind() == reflect.Map:
+		ent.parent.SetMapIndex(ent.key, val)
+	default:
+		return &Unsettable{ent.path, ent.value.Interface()}
+	}
+	ent.attach()
+	return nil
+}
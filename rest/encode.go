@@ -0,0 +1,399 @@
+package rest
+
+import (
+	"bytes"
+	"json"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A fieldSet is a tree of the fields selected from a value, keyed by the
+// lower-case names used for them in paths.  A nil fieldSet selects every
+// field.
+type fieldSet map[string]fieldSet
+
+// parseFields parses a list of dotted field paths separated by ",".
+func parseFields(list string) fieldSet {
+	fields := fieldSet{}
+	for _, path := range strings.Split(strings.ToLower(list), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		set := fields
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			sub, ok := set[name]
+			if i == len(names)-1 {
+				// The whole field is selected
+				set[name] = nil
+				break
+			}
+			if ok && sub == nil {
+				// The whole field is already selected
+				break
+			}
+			if !ok {
+				sub = fieldSet{}
+				set[name] = sub
+			}
+			set = sub
+		}
+	}
+	return fields
+}
+
+// An encoder writes the JSON encoding of a value by walking it, rather than
+// by marshalling it, so that only the selected parts of it are visited.
+// Structures and collections nested more deeply than the maximum depth are
+// replaced by stubs which link to them.  If hal is set, structures, maps and
+// slices are written as HAL objects with links to themselves and to each of
+// their children.
+type encoder struct {
+	buf   *bytes.Buffer
+	depth int
+	props []*property
+	index []int
+	hal   bool
+}
+
+// A member is a field of a structure or an element of a map which is included
+// in its encoding.
+type member struct {
+	key    string // the name of the member in the encoding
+	name   string // the path segment which names it
+	value  reflect.Value
+	fields fieldSet
+}
+
+// link writes a link object for the value at path.
+func (e *encoder) link(path string) os.Error {
+	href, err := json.Marshal(path)
+	if err != nil {
+		return err
+	}
+	e.buf.WriteString(`{"href":`)
+	e.buf.Write(href)
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// stub writes a link to the value at path in place of the value itself.
+func (e *encoder) stub(path string) os.Error {
+	if !e.hal {
+		return e.link(path)
+	}
+	e.buf.WriteString(`{"_links":{"self":`)
+	if err := e.link(path); err != nil {
+		return err
+	}
+	e.buf.WriteString(`}}`)
+	return nil
+}
+
+// links writes the _links member of the HAL object at path, which links to
+// the object itself and to each of its members by their keys.
+func (e *encoder) links(path string, members []member) os.Error {
+	e.buf.WriteString(`"_links":{"self":`)
+	if err := e.link(path); err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.key == "self" {
+			continue
+		}
+		e.member(m.key, false)
+		if err := e.link(subPath(path, m.name)); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// encode writes the encoding of v, which is found at path, at the given level
+// of nesting (the root is at level 0).  Only the given fields of structures
+// and maps are included, and they are selected from each element of a slice
+// or array.
+func (e *encoder) encode(v reflect.Value, path string, fields fieldSet, level int) os.Error {
+	v = indirect(v)
+	if !v.IsValid() || v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		e.buf.WriteString("null")
+		return nil
+	}
+
+	if _, ok := v.Interface().(json.Marshaler); ok {
+		return e.marshal(v)
+	}
+	if v.CanAddr() {
+		if _, ok := v.Addr().Interface().(json.Marshaler); ok {
+			return e.marshal(v.Addr())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		if e.depth >= 0 && level >= e.depth {
+			return e.stub(path)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		members, err := e.structMembers(v, fields, level)
+		if err != nil {
+			return err
+		}
+		return e.object(path, members, level)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		return e.object(path, mapMembers(v, fields), level)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.marshal(v)
+		}
+		if v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		return e.list(v, path, fields, level)
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		e.buf.WriteString("null")
+		return nil
+	}
+	return e.marshal(v)
+}
+
+// marshal writes the encoding of v produced by the json package.
+func (e *encoder) marshal(v reflect.Value) os.Error {
+	js, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	e.buf.Write(js)
+	return nil
+}
+
+// member writes the name of a member of an object, preceded by a comma if it
+// is not the first.
+func (e *encoder) member(name string, first bool) {
+	if !first {
+		e.buf.WriteByte(',')
+	}
+	js, _ := json.Marshal(name)
+	e.buf.Write(js)
+	e.buf.WriteByte(':')
+}
+
+// object writes an object with the given members, which are found below path.
+func (e *encoder) object(path string, members []member, level int) os.Error {
+	e.buf.WriteByte('{')
+	if e.hal {
+		if err := e.links(path, members); err != nil {
+			return err
+		}
+	}
+	for i, m := range members {
+		e.member(m.key, i == 0 && !e.hal)
+		if err := e.encode(m.value, subPath(path, m.name), m.fields, level+1); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// list writes the elements of a slice or array, which is found at path.  In
+// HAL, the list is an object in which the elements are embedded as "item".
+func (e *encoder) list(v reflect.Value, path string, fields fieldSet, level int) os.Error {
+	keyed := keyField(v.Type().Elem())
+	names := make([]string, v.Len())
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+		if level == 0 && e.index != nil {
+			names[i] = strconv.Itoa(e.index[i])
+		}
+		if k := indirect(v.Index(i)); keyed >= 0 && k.Kind() == reflect.Struct {
+			names[i] = formatKey(k.Field(keyed))
+		}
+	}
+
+	if e.hal {
+		e.buf.WriteString(`{"_links":{"self":`)
+		if err := e.link(path); err != nil {
+			return err
+		}
+		e.buf.WriteString(`,"item":[`)
+		for i, name := range names {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			if err := e.link(subPath(path, name)); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteString(`]},"_embedded":{"item":`)
+	}
+
+	e.buf.WriteByte('[')
+	for i, name := range names {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		if err := e.encode(v.Index(i), subPath(path, name), fields, level+1); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+
+	if e.hal {
+		e.buf.WriteString(`}}`)
+	}
+	return nil
+}
+
+// structMembers returns the members of the structure v which are included in
+// its encoding: its exported fields and, at the root, the properties.
+func (e *encoder) structMembers(v reflect.Value, fields fieldSet, level int) ([]member, os.Error) {
+	var members []member
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.ToLower(f.Name)
+		sub, ok := fields[name]
+		if fields != nil && !ok {
+			continue
+		}
+
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			continue
+		}
+
+		key, omitempty := jsonKey(f)
+		if key == "" || omitempty && empty(fv) {
+			continue
+		}
+
+		members = append(members, member{key, name, fv, sub})
+	}
+
+	if level == 0 {
+		for _, prop := range e.props {
+			name := strings.ToLower(prop.name)
+			sub, ok := fields[name]
+			if fields != nil && !ok {
+				continue
+			}
+			pv, err := prop.get()
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member{prop.name, name, pv, sub})
+		}
+	}
+	return members, nil
+}
+
+// mapMembers returns the elements of the map v which are included in its
+// encoding, sorted by key.
+func mapMembers(v reflect.Value, fields fieldSet) []member {
+	keys := make(map[string]reflect.Value, v.Len())
+	names := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		name := formatKey(key)
+		if _, ok := fields[strings.ToLower(name)]; fields != nil && !ok {
+			continue
+		}
+		keys[name] = key
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	members := make([]member, len(names))
+	for i, name := range names {
+		members[i] = member{name, name, v.MapIndex(keys[name]), fields[strings.ToLower(name)]}
+	}
+	return members
+}
+
+// jsonKey returns the name under which the json package encodes the field,
+// or "" if it is not encoded, and whether it is omitted when empty.
+func jsonKey(f reflect.StructField) (key string, omitempty bool) {
+	tag := f.Tag.Get("json")
+	if tag == "" {
+		return f.Name, false
+	}
+	opts := strings.Split(tag, ",")
+	switch opts[0] {
+	case "-":
+		return "", false
+	case "":
+		key = f.Name
+	default:
+		key = opts[0]
+	}
+	return key, len(opts) > 1 && opts[1] == "omitempty"
+}
+
+// empty returns true if v is the zero value for the purposes of omitempty.
+func empty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// encode encodes val, the value of the entity or the part of it that was
+// requested, with the given fields and to the given depth (which are taken
+// from the fields and depth query parameters), as plain JSON or as HAL.
+func (ent *entity) encode(val reflect.Value, index []int, fields, depth string, hal bool) ([]byte, os.Error) {
+	e := &encoder{
+		buf:   bytes.NewBuffer(nil),
+		depth: -1,
+		props: ent.props,
+		index: index,
+		hal:   hal,
+	}
+
+	if depth != "" {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 0 {
+			return nil, &BadQuery{ent.path, os.NewError("invalid depth " + strconv.Quote(depth))}
+		}
+		e.depth = n
+	}
+
+	var set fieldSet
+	if fields != "" {
+		set = parseFields(fields)
+	}
+
+	if err := e.encode(val, ent.path, set, 0); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"http"
 	"os"
+	"reflect"
+	"strings"
 )
 
 type UnhandledType struct {
@@ -18,9 +20,10 @@ func (e *UnhandledType) ErrorCode() int {
 }
 
 type BadMethod struct {
-	Path   string
-	Method string
-	Object interface{}
+	Path    string
+	Method  string
+	Object  interface{}
+	Allowed []string
 }
 func (e *BadMethod) String() string {
 	return fmt.Sprintf("rest: %s unsupported for %T", e.Method, e.Object)
@@ -28,6 +31,9 @@ func (e *BadMethod) String() string {
 func (e *BadMethod) ErrorCode() int {
 	return http.StatusMethodNotAllowed
 }
+func (e *BadMethod) AllowedMethods() []string {
+	return e.Allowed
+}
 
 type BadSub struct {
 	ResURI string
@@ -49,3 +55,162 @@ type FailedEncode struct {
 func (e *FailedEncode) String() string {
 	return fmt.Sprintf("rest: encoding %T as %s: %s", e.Object, e.Media, e.Err)
 }
+
+type FailedDecode struct {
+	Err    os.Error
+	Media  string
+	Object interface{}
+}
+func (e *FailedDecode) String() string {
+	return fmt.Sprintf("rest: decoding %s as %T: %s", e.Media, e.Object, e.Err)
+}
+func (e *FailedDecode) ErrorCode() int {
+	return http.StatusBadRequest
+}
+
+type Unsettable struct {
+	Path   string
+	Object interface{}
+}
+func (e *Unsettable) String() string {
+	return fmt.Sprintf("rest: %s (%T) cannot be modified", e.Path, e.Object)
+}
+func (e *Unsettable) ErrorCode() int {
+	return http.StatusForbidden
+}
+
+type UnknownType struct {
+	Path string
+	Name string
+	Want reflect.Type
+}
+func (e *UnknownType) String() string {
+	if e.Name == "" {
+		return fmt.Sprintf("rest: %s (%v) requires a %q member to select its type", e.Path, e.Want, TypeField)
+	}
+	return fmt.Sprintf("rest: %s (%v) cannot hold type %q", e.Path, e.Want, e.Name)
+}
+func (e *UnknownType) ErrorCode() int {
+	return http.StatusBadRequest
+}
+
+type Invalid struct {
+	Path string
+	Err  os.Error
+}
+func (e *Invalid) String() string {
+	return fmt.Sprintf("rest: invalid value for %s: %s", e.Path, e.Err)
+}
+func (e *Invalid) ErrorCode() int {
+	if err, ok := e.Err.(ErrorCoder); ok {
+		return err.ErrorCode()
+	}
+	return http.StatusBadRequest
+}
+
+type Closed struct {
+	Path   string
+	Object interface{}
+}
+func (e *Closed) String() string {
+	return fmt.Sprintf("rest: %s (%T) is closed", e.Path, e.Object)
+}
+func (e *Closed) ErrorCode() int {
+	return http.StatusGone
+}
+
+type Timeout struct {
+	Path   string
+	Object interface{}
+}
+func (e *Timeout) String() string {
+	return fmt.Sprintf("rest: %s (%T) timed out", e.Path, e.Object)
+}
+func (e *Timeout) ErrorCode() int {
+	return http.StatusServiceUnavailable
+}
+
+type Duplicate struct {
+	Path string
+	Key  string
+}
+func (e *Duplicate) String() string {
+	return fmt.Sprintf("rest: %s already has an element %q", e.Path, e.Key)
+}
+func (e *Duplicate) ErrorCode() int {
+	return http.StatusConflict
+}
+
+type BadQuery struct {
+	Path string
+	Err  os.Error
+}
+func (e *BadQuery) String() string {
+	return fmt.Sprintf("rest: bad query for %s: %s", e.Path, e.Err)
+}
+func (e *BadQuery) ErrorCode() int {
+	return http.StatusBadRequest
+}
+
+type BadRange struct {
+	Path  string
+	Start int
+	Total int
+}
+func (e *BadRange) String() string {
+	return fmt.Sprintf("rest: %s has %d items, none at %d", e.Path, e.Total, e.Start)
+}
+func (e *BadRange) ErrorCode() int {
+	return http.StatusRequestedRangeNotSatisfiable
+}
+
+type NotAcceptable struct {
+	Path   string
+	Accept []string
+}
+func (e *NotAcceptable) String() string {
+	return fmt.Sprintf("rest: %s cannot be represented as %s", e.Path, strings.Join(e.Accept, ", "))
+}
+func (e *NotAcceptable) ErrorCode() int {
+	return http.StatusNotAcceptable
+}
+
+type SchemaError struct {
+	Path       string
+	Violations []Violation
+}
+func (e *SchemaError) String() string {
+	lines := []string{fmt.Sprintf("rest: value for %s does not match its schema:", e.Path)}
+	for _, v := range e.Violations {
+		lines = append(lines, fmt.Sprintf("%q: %s", v.Pointer, v.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+func (e *SchemaError) ErrorCode() int {
+	return http.StatusBadRequest
+}
+
+type PreconditionFailed struct {
+	Path string
+	ETag string
+}
+func (e *PreconditionFailed) String() string {
+	if e.ETag == "" {
+		return fmt.Sprintf("rest: precondition failed for %s, which does not exist", e.Path)
+	}
+	return fmt.Sprintf("rest: precondition failed for %s, whose entity tag is %s", e.Path, e.ETag)
+}
+func (e *PreconditionFailed) ErrorCode() int {
+	return http.StatusPreconditionFailed
+}
+
+type NoVersion struct {
+	Path    string
+	Version int64
+}
+func (e *NoVersion) String() string {
+	return fmt.Sprintf("rest: %s has no version %d in its history", e.Path, e.Version)
+}
+func (e *NoVersion) ErrorCode() int {
+	return http.StatusNotFound
+}
@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"http"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// etag returns the entity tag of val as the value of the entity, which is
+// derived from its plain JSON representation (including any properties).
+func (ent *entity) etag(val reflect.Value) string {
+	js, err := jsonCodec{}.Encode(val, "")
+	if err == nil && len(ent.props) > 0 {
+		js, err = addProperties(js, ent.props)
+	}
+	if err != nil {
+		return ""
+	}
+	return hashTag(js)
+}
+
+// hashTag returns the entity tag of a representation.
+func hashTag(data []byte) string {
+	h := sha1.New()
+	h.Write(data)
+	return fmt.Sprintf(`"%x"`, h.Sum())
+}
+
+// matchTag returns true if the list of entity tags in an If-Match or
+// If-None-Match header includes tag, which is "" if there is no current value.
+// Weak tags are compared as if they were strong.
+func matchTag(list, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if strings.HasPrefix(t, "W/") {
+			t = t[2:]
+		}
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition checks the If-Match and If-None-Match headers of a request to
+// modify the entity against its current entity tag.  (An If-None-Match on a
+// GET is handled by get, which replies 304 Not Modified.)  Functions and
+// channels have no entity tags, so they are never checked.
+func (ent *entity) precondition(r *http.Request) os.Error {
+	switch ent.value.Kind() {
+	case reflect.Func, reflect.Chan:
+		return nil
+	}
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+
+	tag := ""
+	if !ent.created {
+		tag = ent.etag(ent.value)
+	}
+	if ifMatch != "" && !matchTag(ifMatch, tag) {
+		return &PreconditionFailed{ent.path, tag}
+	}
+	if ifNoneMatch != "" && r.Method != "GET" && r.Method != "HEAD" && matchTag(ifNoneMatch, tag) {
+		return &PreconditionFailed{ent.path, tag}
+	}
+	return nil
+}
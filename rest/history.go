@@ -0,0 +1,208 @@
+package rest
+
+import (
+	"http"
+	"json"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// HistorySize is the number of versions of its value which a newly mapped
+// Resource keeps, for clients to browse and roll back to.  Since every
+// modification then encodes the whole value, it is 0 by default; resources
+// opt in with KeepHistory.
+var HistorySize = 0
+
+// A Version describes a version of the value of a Resource, as it is listed
+// in its history.
+type Version struct {
+	Version int64
+	Time    string
+
+	value []byte
+}
+
+// KeepHistory sets the number of versions of its value which the resource
+// keeps.  If n is 0, no history is kept.
+func (res *Resource) KeepHistory(n int) {
+	res.lock.Lock()
+	defer res.lock.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	res.keep = n
+	if len(res.history) > n {
+		res.history = res.history[len(res.history)-n:]
+	}
+}
+
+// remember adds the current value of the resource to its history; the
+// resource must be locked.  Values which cannot be encoded are not kept.
+func (res *Resource) remember() {
+	if res.keep <= 0 {
+		return
+	}
+	js, err := jsonCodec{}.Encode(res.value, res.path)
+	if err != nil {
+		return
+	}
+	res.history = append(res.history, &Version{
+		Version: res.version,
+		Time:    time.UTC().Format(time.RFC3339),
+		value:   js,
+	})
+	if len(res.history) > res.keep {
+		res.history = res.history[len(res.history)-res.keep:]
+	}
+}
+
+// wantsHistory returns true if the request is for the history of the
+// resource.
+func wantsHistory(r *http.Request) bool {
+	q := r.URL.Query()
+	switch r.Method {
+	case "GET", "HEAD":
+		for _, name := range []string{"history", "version", "diff"} {
+			if _, ok := q[name]; ok {
+				return true
+			}
+		}
+	case "POST":
+		_, ok := q["rollback"]
+		return ok
+	}
+	return false
+}
+
+// serveHistory serves the history of the value at path below the resource:
+//   ?history lists the versions which are kept
+//   ?version=N serves version N of the value as a GET would
+//   ?diff=N serves the JSON Patch from version N to the current value, or
+//     with &to=M, to version M
+//   POST ?rollback=N replaces the value with version N
+func (res *Resource) serveHistory(w http.ResponseWriter, r *http.Request, path string) os.Error {
+	q := r.URL.Query()
+	if _, ok := q["history"]; ok {
+		list := res.history
+		if list == nil {
+			list = []*Version{}
+		}
+		js, err := json.Marshal(list)
+		if err != nil {
+			return &FailedEncode{err, "application/json", list}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "HEAD" {
+			return nil
+		}
+		_, err = w.Write(js)
+		return err
+	}
+
+	if q.Get("version") != "" {
+		old, err := res.old(q.Get("version"), path)
+		if err != nil {
+			return err
+		}
+		return old.get(w, r)
+	}
+
+	if q.Get("diff") != "" {
+		from, err := res.old(q.Get("diff"), path)
+		if err != nil {
+			return err
+		}
+		to, err := res.resolve(path, false)
+		if q.Get("to") != "" {
+			to, err = res.old(q.Get("to"), path)
+		}
+		if err != nil {
+			return err
+		}
+		return sendDiff(w, r, from.value, to.value)
+	}
+
+	old, err := res.old(q.Get("rollback"), path)
+	if err != nil {
+		return err
+	}
+	ent, err := res.resolve(path, false)
+	if err != nil {
+		return err
+	}
+	if err := ent.precondition(r); err != nil {
+		return err
+	}
+	var u undo
+	saved := false
+	if res.persisted() {
+		u, saved = res.saveUndo(r.Method, path)
+	}
+	if err := ent.set(old.value); err != nil {
+		return err
+	}
+	if err := res.modified(nil, nil); err != nil {
+		if saved {
+			res.revert(u)
+		}
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// old returns the entity at path in the version of the resource named by
+// the query parameter v.  The entity is decoded from the history, so it is
+// read-only.
+func (res *Resource) old(v string, path string) (*entity, os.Error) {
+	n, err := strconv.Atoi64(v)
+	if err != nil {
+		return nil, &BadQuery{res.path, os.NewError("bad version " + strconv.Quote(v))}
+	}
+
+	var ver *Version
+	for _, h := range res.history {
+		if h.Version == n {
+			ver = h
+		}
+	}
+	if ver == nil {
+		return nil, &NoVersion{res.path, n}
+	}
+
+	// The parts of the value which are not encoded are those of the current one
+	val, err := decodeJSON(ver.value, replacement(res.value))
+	if err != nil {
+		return nil, err
+	}
+	old := &Resource{
+		ro:    true,
+		path:  res.path,
+		kind:  res.kind,
+		value: val,
+	}
+	return old.resolve(path, false)
+}
+
+// sendDiff sends the JSON Patch which transforms the value a into the value b.
+func sendDiff(w http.ResponseWriter, r *http.Request, a, b reflect.Value) os.Error {
+	ops, err := diff("", a, b)
+	if err != nil {
+		return err
+	}
+	if ops == nil {
+		ops = []patchOp{}
+	}
+	js, err := json.Marshal(ops)
+	if err != nil {
+		return &FailedEncode{err, PatchType, ops}
+	}
+	w.Header().Set("Content-Type", PatchType)
+	if r.Method == "HEAD" {
+		return nil
+	}
+	_, err = w.Write(js)
+	return err
+}
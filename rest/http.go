@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"bytes"
 	"http"
 	"log"
 	"os"
@@ -27,6 +28,91 @@ type ErrorCoder interface {
 	ErrorCode() int
 }
 
+// An Allower is an error which knows the methods that would have been allowed
+// for the request; they are sent to the client in the Allow header.
+type Allower interface {
+	AllowedMethods() []string
+}
+
+// A responseWriter keeps track of whether the status line and headers have
+// been sent to the client and of the lock held on the resource.
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	unlocker func()
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, os.Error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) unlock() {
+	if w.unlocker != nil {
+		w.unlocker()
+		w.unlocker = nil
+	}
+}
+
+// A recorder is a ResponseWriter which keeps the reply, so that it can be
+// sent later (or not at all).
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+func (rec *recorder) Write(p []byte) (int, os.Error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// send sends the recorded reply to w.
+func (rec *recorder) send(w http.ResponseWriter) os.Error {
+	for key, values := range rec.header {
+		w.Header()[key] = values
+	}
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+	if rec.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(rec.body.Bytes())
+	return err
+}
+
+// unlock releases the lock held on the resource being served to w, if any.
+// It is called by handlers which may block for a long time and do not need
+// the resource to remain locked while they do.
+func unlock(w http.ResponseWriter) {
+	if rw, ok := w.(*responseWriter); ok {
+		rw.unlock()
+	}
+}
+
 // Handle maps the given handler (typically a *Resource) at the given path and
 // provides a first level of logging, locking, and access control for the
 // resource.
@@ -42,14 +128,28 @@ type ErrorCoder interface {
 //
 // If the method is a "safe" method (e.g. GET), the resource is locked for
 // reading.  If the method is an "unsafe" method (e.g. PUT), the resource is
-// locked for writing.  The resource is unlocked when the request handling
-// completes.
+// locked for writing, unless the request is a dry run (which is served
+// against a copy of the resource) or asks for a diff (which does not modify
+// the resource, and so may be made to a read-only resource).  The resource is unlocked when the request
+// handling completes, or earlier if the request may block for a long time
+// (for instance, when a channel is being received from).
+//
+// Every handler mapped with Handle (or Map) is listed in the index served at
+// IndexPath.
+//
+// If the handler does not write a status code itself (for instance, 201
+// Created or 204 No Content), an HTTP OK response is sent when it returns.
 //
 // Errors returned by rhe ServeREST function of the handler are sent to the
 // client.  By default, these are sent with an HTTP Internal Server Error
 // response, but if the error has an ErrorCode() int method, the return value
-// of that method will be used is the status code instead.
+// of that method will be used is the status code instead.  If the error has an
+// AllowedMethods() []string method, they are listed in the Allow header.  If
+// the client accepts application/problem+json, the error is sent as a Problem
+// rather than as plain text.  If the handler has already sent a status code,
+// the error is only logged.
 func Handle(path string, handler Handler) {
+	register(path, handler)
 	DefaultServeMux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
 		log := func(message string) {
 			log.Printf("rest: %s: %s", r.RemoteAddr, message)
@@ -60,16 +160,30 @@ func Handle(path string, handler Handler) {
 			res = r
 		}
 
+		rw := &responseWriter{ResponseWriter: w}
+		defer rw.unlock()
+
 		switch r.Method {
 		case "POST", "PUT", "DELETE", "PATCH":
 			if res != nil {
+				// Diffs only read the resource
+				if wantsDiff(r) {
+					res.lock.RLock()
+					rw.unlocker = func() { res.lock.RUnlock() }
+					break
+				}
 				if res.ro {
 					log("attempt to modify read-only resource "+r.URL.Path+" blocked")
 					http.Error(w, "Read-Only Resource", http.StatusForbidden)
 					return
 				}
+				if isDryRun(r) {
+					res.lock.RLock()
+					rw.unlocker = func() { res.lock.RUnlock() }
+					break
+				}
 				res.lock.Lock()
-				defer res.lock.Unlock()
+				rw.unlocker = func() { res.lock.Unlock() }
 			}
 		case "CONNECT":
 			log("CONNECT attempt blocked")
@@ -78,7 +192,7 @@ func Handle(path string, handler Handler) {
 		case "GET", "HEAD":
 			if res != nil {
 				res.lock.RLock()
-				defer res.lock.RUnlock()
+				rw.unlocker = func() { res.lock.RUnlock() }
 			}
 		case "OPTIONS":
 			allow := []string{"OPTIONS", "HEAD", "GET", "POST", "PATCH", "PUT", "DELETE"}
@@ -92,19 +206,29 @@ func Handle(path string, handler Handler) {
 			return
 		}
 
-		err := handler.ServeREST(w, r)
+		err := handler.ServeREST(rw, r)
 		if err == nil {
-			w.WriteHeader(http.StatusOK)
+			rw.WriteHeader(http.StatusOK)
 			return
 		}
 
 		log(err.String())
 
+		if rw.status != 0 {
+			return
+		}
+
+		if err, ok := err.(Allower); ok {
+			w.Header().Set("Allow", strings.Join(err.AllowedMethods(), ", "))
+		}
+
 		status := http.StatusInternalServerError
 		if err, ok := err.(ErrorCoder); ok {
 			status = err.ErrorCode()
 		}
 
-		http.Error(w, err.String(), status)
+		if !sendProblem(w, r, err, status) {
+			http.Error(w, err.String(), status)
+		}
 	})
 }
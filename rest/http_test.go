@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"http"
 	"http/httptest"
+	"io/ioutil"
+	"json"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -24,6 +30,90 @@ var testObject = testObjectType{
 	},
 }
 
+var writeObject = testObjectType{
+	Numbers: []int{1, 2},
+	Map:     map[string]bool{},
+}
+
+type nilObjectType struct {
+	Int *int
+	Sub *testObjectType
+	Map map[string]int
+	Any interface{}
+}
+
+var nilObject nilObjectType
+
+type testStorage interface {
+	Size() int
+}
+
+type memStorage struct {
+	Bytes int
+}
+
+func (m *memStorage) Size() int { return m.Bytes }
+
+type diskStorage struct {
+	Path  string
+	Bytes int
+}
+
+func (d diskStorage) Size() int { return d.Bytes }
+
+type ifaceObjectType struct {
+	Backend interface{}
+	Storage testStorage
+}
+
+var ifaceObject = ifaceObjectType{
+	Backend: &testObjectType{String: "backend"},
+	Storage: diskStorage{"/tmp", 10},
+}
+
+func init() {
+	RegisterType("mem", &memStorage{})
+	RegisterType("disk", diskStorage{})
+}
+
+type counter struct {
+	Count int
+	Step  func(int) int
+}
+
+func (c *counter) Total() int                       { return c.Count }
+func (c *counter) Add(n int) int                    { c.Count += n; return c.Count }
+func (c *counter) Reset()                           { c.Count = 0 }
+func (c *counter) Fail(code int) os.Error           { return errorResponder(code) }
+func (c *counter) Swap(a, b string) (string, string) { return b, a }
+
+var counterObject = counter{
+	Step: func(n int) int { return 2 * n },
+}
+
+type lockedCounter struct {
+	sync.Mutex
+	N int
+}
+
+func (c *lockedCounter) Total() int { return c.N }
+
+// Unlock shadows the method of the embedded sync.Mutex.
+func (c *lockedCounter) Unlock() { c.N = 0 }
+
+func TestPromotedMethods(t *testing.T) {
+	v := reflect.ValueOf(&lockedCounter{}).Elem()
+	if m := method(v, "lock"); m.IsValid() {
+		t.Errorf("method(lock) found a method promoted from sync.Mutex")
+	}
+	if m := method(v, "total"); !m.IsValid() {
+		t.Errorf("method(total) = invalid, want the Total method")
+	}
+	if m := method(v, "unlock"); !m.IsValid() {
+		t.Errorf("method(unlock) = invalid, want the Unlock method of lockedCounter")
+	}
+}
+
 var mapTests = []struct {
 	InPath  string
 	InObj   interface{}
@@ -67,6 +157,38 @@ var mapTests = []struct {
 		OutKind: reflect.String,
 		OutRO:   true,
 	},
+	{
+		InPath:  "/nil",
+		InObj:   &nilObject,
+		OutPath: "/nil/",
+		OutType: reflect.TypeOf(nilObjectType{}),
+		OutKind: reflect.Struct,
+		OutRO:   false,
+	},
+	{
+		InPath:  "/iface",
+		InObj:   &ifaceObject,
+		OutPath: "/iface/",
+		OutType: reflect.TypeOf(ifaceObjectType{}),
+		OutKind: reflect.Struct,
+		OutRO:   false,
+	},
+	{
+		InPath:  "/counter",
+		InObj:   &counterObject,
+		OutPath: "/counter/",
+		OutType: reflect.TypeOf(counter{}),
+		OutKind: reflect.Struct,
+		OutRO:   false,
+	},
+	{
+		InPath:  "/write",
+		InObj:   &writeObject,
+		OutPath: "/write/",
+		OutType: reflect.TypeOf(testObjectType{}),
+		OutKind: reflect.Struct,
+		OutRO:   false,
+	},
 }
 
 func TestMap(t *testing.T) {
@@ -122,10 +244,11 @@ var handleTests = []struct {
 	{"/missing/", "GET", "", http.StatusNotFound, ""},
 	{"/mutable/", "GET", "", http.StatusOK, ""},
 	{"/mutable/", "HEAD", "", http.StatusOK, ""},
-	{"/mutable/", "DELETE", "", http.StatusOK, ""},
-	{"/mutable/", "PATCH", "", http.StatusOK, ""},
-	{"/mutable/", "POST", "", http.StatusOK, ""},
-	{"/mutable/", "PUT", "", http.StatusOK, ""},
+	{"/mutable/", "DELETE", "", http.StatusMethodNotAllowed, ""},
+	{"/mutable/", "PATCH", "", http.StatusBadRequest, ""},
+	{"/mutable/", "POST", "", http.StatusMethodNotAllowed, ""},
+	{"/mutable/", "PUT", `{"String":"teststr"}`, http.StatusNoContent, ""},
+	{"/mutable/", "PUT", "", http.StatusBadRequest, ""},
 	{"/readonly/", "GET", "", http.StatusOK, ""},
 	{"/readonly/", "HEAD", "", http.StatusOK, ""},
 	{"/readonly/", "DELETE", "", http.StatusForbidden, ""},
@@ -143,6 +266,11 @@ var handleTests = []struct {
 	{"/mutable/nUMbErs", "GET", "", http.StatusOK, "[6,9,42]"},
 	{"/mutable/numbers/2", "GET", "", http.StatusOK, "42"},
 	{"/mutable/numbers/true", "GET", "", http.StatusNotFound, ""},
+	{"/mutable/numbers/3", "GET", "", http.StatusNotFound, ""},
+	{"/mutable/numbers/-1", "GET", "", http.StatusOK, "42"},
+	{"/mutable/numbers/-3", "GET", "", http.StatusOK, "6"},
+	{"/mutable/numbers/-4", "GET", "", http.StatusNotFound, ""},
+	{"/mutable/numbers/-", "GET", "", http.StatusNotFound, ""},
 	{"/mutable/map", "GET", "", http.StatusOK, `{"false":false,"true":true}`},
 	{"/mutable/map/true", "GET", "", http.StatusOK, `true`},
 	{"/mutable/map/2", "GET", "", http.StatusNotFound, ""},
@@ -171,12 +299,1338 @@ func TestHandle(t *testing.T) {
 	}
 }
 
+type writeTest struct {
+	Path     string
+	Method   string
+	Body     string
+	Code     int
+	Header   string
+	Value    string
+	Contains string
+}
+
+var writeTests = []writeTest{
+	{"/write/", "DELETE", "", http.StatusMethodNotAllowed, "Allow", "OPTIONS, HEAD, GET, PATCH, PUT", ""},
+	{"/write/numbers", "PATCH", "", http.StatusMethodNotAllowed, "Allow", "OPTIONS, HEAD, GET, POST, PUT", ""},
+	{"/write/string", "PUT", `"written"`, http.StatusNoContent, "", "", ""},
+	{"/write/string", "GET", "", http.StatusOK, "", "", `"written"`},
+	{"/write/", "PUT", `{"Numbers":[3]}`, http.StatusNoContent, "", "", ""},
+	{"/write/", "GET", "", http.StatusOK, "", "", `{"String":"written","Numbers":[3],"Map":{}}`},
+	{"/write/numbers", "POST", "4", http.StatusCreated, "Location", "/write/numbers/1", ""},
+	{"/write/numbers", "POST", "5", http.StatusCreated, "Location", "/write/numbers/2", ""},
+	{"/write/numbers/0", "DELETE", "", http.StatusNoContent, "", "", ""},
+	{"/write/numbers", "GET", "", http.StatusOK, "", "", "[4,5]"},
+	{"/write/numbers/0", "PUT", "x", http.StatusBadRequest, "", "", ""},
+	{"/write/numbers/2", "PUT", "6", http.StatusNotFound, "", "", ""},
+	{"/write/numbers/-", "PUT", "6", http.StatusCreated, "Location", "/write/numbers/2", ""},
+	{"/write/numbers/-1", "GET", "", http.StatusOK, "", "", "6"},
+	{"/write/numbers/-1", "PUT", "7", http.StatusNoContent, "", "", ""},
+	{"/write/numbers", "GET", "", http.StatusOK, "", "", "[4,5,7]"},
+	{"/write/numbers/-1", "DELETE", "", http.StatusNoContent, "", "", ""},
+	{"/write/numbers", "GET", "", http.StatusOK, "", "", "[4,5]"},
+	{"/write/map/a", "PUT", "true", http.StatusCreated, "Location", "/write/map/a", ""},
+	{"/write/map/a", "PUT", "false", http.StatusNoContent, "", "", ""},
+	{"/write/map", "POST", `{"b":true}`, http.StatusCreated, "Location", "/write/map/b", ""},
+	{"/write/map", "GET", "", http.StatusOK, "", "", `{"a":false,"b":true}`},
+	{"/write/map/a", "DELETE", "", http.StatusNoContent, "", "", ""},
+	{"/write/map", "GET", "", http.StatusOK, "", "", `{"b":true}`},
+	{"/write/map/a", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/nil/", "GET", "", http.StatusOK, "", "", `{"Int":null,"Sub":null,"Map":null,"Any":null}`},
+	{"/nil/int", "GET", "", http.StatusOK, "", "", "null"},
+	{"/nil/sub/string", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/nil/any/string", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/nil/map/a", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/nil/int", "PUT", "3", http.StatusNoContent, "", "", ""},
+	{"/nil/int", "GET", "", http.StatusOK, "", "", "3"},
+	{"/nil/sub/string", "PUT", `1`, http.StatusBadRequest, "", "", ""},
+	{"/nil/sub", "GET", "", http.StatusOK, "", "", "null"},
+	{"/nil/sub/string", "PUT", `"alloc"`, http.StatusNoContent, "", "", ""},
+	{"/nil/sub", "GET", "", http.StatusOK, "", "", `{"String":"alloc","Numbers":null,"Map":null}`},
+	{"/nil/sub/map/a", "PUT", `"x"`, http.StatusBadRequest, "", "", ""},
+	{"/nil/sub/map", "GET", "", http.StatusOK, "", "", "null"},
+	{"/nil/sub/map/a", "PUT", "true", http.StatusCreated, "Location", "/nil/sub/map/a", ""},
+	{"/nil/map", "POST", `{"a":1}`, http.StatusCreated, "Location", "/nil/map/a", ""},
+	{"/nil/map", "GET", "", http.StatusOK, "", "", `{"a":1}`},
+	{"/iface/backend/string", "GET", "", http.StatusOK, "", "", `"backend"`},
+	{"/iface/backend/string", "PUT", `"changed"`, http.StatusNoContent, "", "", ""},
+	{"/iface/backend/string", "GET", "", http.StatusOK, "", "", `"changed"`},
+	{"/iface/storage/path", "GET", "", http.StatusOK, "", "", `"/tmp"`},
+	{"/iface/storage/path", "PUT", `"/var"`, http.StatusMethodNotAllowed, "Allow", "OPTIONS, HEAD, GET", ""},
+	{"/iface/storage", "PUT", `{"Bytes":20}`, http.StatusNoContent, "", "", ""},
+	{"/iface/storage", "GET", "", http.StatusOK, "", "", `{"Path":"/tmp","Bytes":20}`},
+	{"/iface/storage", "PUT", `{"_type":"mem","Bytes":5}`, http.StatusNoContent, "", "", ""},
+	{"/iface/storage", "GET", "", http.StatusOK, "", "", `{"Bytes":5}`},
+	{"/iface/storage/bytes", "PUT", "6", http.StatusNoContent, "", "", ""},
+	{"/iface/storage", "GET", "", http.StatusOK, "", "", `{"Bytes":6}`},
+	{"/iface/storage", "PUT", `{"_type":"none"}`, http.StatusBadRequest, "", "", ""},
+	{"/iface/storage", "PUT", `{"_type":"disk","Path":"/"}`, http.StatusNoContent, "", "", ""},
+	{"/iface/storage", "GET", "", http.StatusOK, "", "", `{"Path":"/","Bytes":0}`},
+	{"/iface/storage/size", "GET", "", http.StatusOK, "", "", "0"},
+	{"/counter/total", "GET", "", http.StatusOK, "", "", "0"},
+	{"/counter/add", "POST", "5", http.StatusOK, "", "", "5"},
+	{"/counter/total", "GET", "", http.StatusOK, "", "", "5"},
+	{"/counter/add", "GET", "", http.StatusMethodNotAllowed, "Allow", "OPTIONS, POST", ""},
+	{"/counter/add", "POST", `"x"`, http.StatusBadRequest, "", "", ""},
+	{"/counter/reset", "GET", "", http.StatusMethodNotAllowed, "Allow", "OPTIONS, POST", ""},
+	{"/counter/fail", "GET", "", http.StatusMethodNotAllowed, "Allow", "OPTIONS, POST", ""},
+	{"/counter/reset", "POST", "", http.StatusNoContent, "", "", ""},
+	{"/counter/count", "GET", "", http.StatusOK, "", "", "0"},
+	{"/counter/fail", "POST", "401", http.StatusUnauthorized, "", "", ""},
+	{"/counter/swap", "POST", `["a","b"]`, http.StatusOK, "", "", `["b","a"]`},
+	{"/counter/swap", "POST", `["a"]`, http.StatusBadRequest, "", "", ""},
+	{"/counter/step", "POST", "2", http.StatusOK, "", "", "4"},
+	{"/counter/total/x", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/counter/missing", "GET", "", http.StatusNotFound, "", "", ""},
+}
+
+func TestWrite(t *testing.T) {
+	runWriteTests(t, writeTests)
+}
+
+func runWriteTests(t *testing.T, tests []writeTest) {
+	for _, test := range tests {
+		desc := test.Method + " " + test.Path
+		r, err := http.NewRequest(test.Method, test.Path, bytes.NewBufferString(test.Body))
+		if err != nil {
+			t.Errorf("%s - newrequest: %s", desc, err)
+			continue
+		}
+		w := httptest.NewRecorder()
+		r.RemoteAddr = "unittest"
+
+		DefaultServeMux.ServeHTTP(w, r)
+		if got, want := w.Code, test.Code; got != want {
+			t.Errorf("%s - code = %v, want %v", desc, got, want)
+		}
+		if test.Header != "" {
+			if got, want := w.HeaderMap.Get(test.Header), test.Value; got != want {
+				t.Errorf("%s - %s = %q, want %q", desc, test.Header, got, want)
+			}
+		}
+		if bytes.Index(w.Body.Bytes(), []byte(test.Contains)) < 0 {
+			t.Errorf("%s - body does not contain %q:", desc, test.Contains)
+			t.Errorf("%s", w.Body.String())
+		}
+	}
+}
+
+type testQueue struct {
+	Name  string
+	Items []string
+	limit int
+}
+
+func (q *testQueue) Validate() os.Error {
+	if len(q.Items) > q.limit {
+		return os.NewError("too many items")
+	}
+	return nil
+}
+
+var queueObject = testQueue{
+	Name:  "q",
+	Items: []string{"a"},
+	limit: 2,
+}
+
+var propertyTests = []writeTest{
+	{"/queue/", "GET", "", http.StatusOK, "", "", `{"Name":"q","Items":["a"],"len":1,"limit":2}`},
+	{"/queue/len", "GET", "", http.StatusOK, "", "", "1"},
+	{"/queue/LEN", "GET", "", http.StatusOK, "", "", "1"},
+	{"/queue/len", "PUT", "3", http.StatusMethodNotAllowed, "Allow", "OPTIONS, HEAD, GET", ""},
+	{"/queue/limit", "OPTIONS", "", http.StatusOK, "Allow", "OPTIONS, HEAD, GET, PUT", ""},
+	{"/queue/limit", "PUT", "3", http.StatusNoContent, "", "", ""},
+	{"/queue/limit", "GET", "", http.StatusOK, "", "", "3"},
+	{"/queue/limit", "PUT", "-1", http.StatusBadRequest, "", "", "negative"},
+	{"/queue/", "PUT", `{"Items":["a","b","c","d"]}`, http.StatusBadRequest, "", "", "too many items"},
+	{"/queue/items", "POST", `"b"`, http.StatusCreated, "", "", ""},
+	{"/queue/", "GET", "", http.StatusOK, "", "", `{"Name":"q","Items":["a","b"],"len":2,"limit":3}`},
+}
+
+func TestProperty(t *testing.T) {
+	q := &queueObject
+	res, err := Map("/queue", q)
+	if err != nil {
+		t.Fatalf("map: %s", err)
+	}
+
+	getLen := func() int { return len(q.Items) }
+	if err := res.Property("len", getLen, nil); err != nil {
+		t.Errorf("len: %s", err)
+	}
+
+	getLimit := func() int { return q.limit }
+	setLimit := func(n int) os.Error {
+		if n < 0 {
+			return os.NewError("negative limit")
+		}
+		q.limit = n
+		return nil
+	}
+	if err := res.Property("limit", getLimit, setLimit); err != nil {
+		t.Errorf("limit: %s", err)
+	}
+
+	if err := res.Property("bad", getLimit, func(string) {}); err == nil {
+		t.Errorf("bad: mismatched setter accepted")
+	}
+	if err := res.Property("LEN", getLen, nil); err == nil {
+		t.Errorf("LEN: duplicate property accepted")
+	}
+	if err := res.Property("items", getLen, nil); err == nil {
+		t.Errorf("items: property named after a field accepted")
+	}
+
+	runWriteTests(t, propertyTests)
+}
+
+var (
+	testChan     = make(chan int, 2)
+	testRecvChan = (<-chan int)(testChan)
+	testSendChan = (chan<- int)(testChan)
+)
+
+var chanTests = []writeTest{
+	{"/chan/", "OPTIONS", "", http.StatusOK, "Allow", "OPTIONS, HEAD, GET, POST", ""},
+	{"/chan/recv/", "POST", "1", http.StatusMethodNotAllowed, "Allow", "OPTIONS, HEAD, GET", ""},
+	{"/chan/send/", "GET", "", http.StatusMethodNotAllowed, "Allow", "OPTIONS, POST", ""},
+	{"/chan/", "GET", "", http.StatusNoContent, "", "", ""},
+	{"/chan/", "POST", "1", http.StatusNoContent, "", "", ""},
+	{"/chan/send/", "POST", "2", http.StatusNoContent, "", "", ""},
+	{"/chan/", "POST", "3", http.StatusServiceUnavailable, "", "", ""},
+	{"/chan/", "POST", `"x"`, http.StatusBadRequest, "", "", ""},
+	{"/chan/recv/", "GET", "", http.StatusOK, "", "", "1"},
+}
+
+func TestChan(t *testing.T) {
+	defer func(timeout int64) { ChanTimeout = timeout }(ChanTimeout)
+	ChanTimeout = 50e6
+
+	for path, ch := range map[string]interface{}{
+		"/chan":      &testChan,
+		"/chan/recv": &testRecvChan,
+		"/chan/send": &testSendChan,
+	} {
+		if _, err := Map(path, ch); err != nil {
+			t.Fatalf("map(%q): %s", path, err)
+		}
+	}
+
+	runWriteTests(t, chanTests)
+
+	testChan <- 3
+	close(testChan)
+
+	for _, stream := range []struct {
+		Accept string
+		Body   string
+	}{
+		{"application/x-ndjson", "2\n3\n"},
+		{"text/event-stream", ""},
+	} {
+		r, _ := http.NewRequest("GET", "/chan/", nil)
+		r.Header.Set("Accept", stream.Accept)
+		w := httptest.NewRecorder()
+
+		DefaultServeMux.ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Errorf("stream %s - code = %v, want %v", stream.Accept, got, want)
+		}
+		if got, want := w.HeaderMap.Get("Content-Type"), stream.Accept; got != want {
+			t.Errorf("stream %s - content-type = %q, want %q", stream.Accept, got, want)
+		}
+		if got, want := w.Body.String(), stream.Body; got != want {
+			t.Errorf("stream %s - body = %q, want %q", stream.Accept, got, want)
+		}
+	}
+
+	runWriteTests(t, []writeTest{
+		{"/chan/", "GET", "", http.StatusGone, "", "", ""},
+		{"/chan/", "POST", "4", http.StatusGone, "", "", ""},
+	})
+}
+
+type testUserID struct {
+	N int
+}
+
+func (u *testUserID) UnmarshalText(text []byte) os.Error {
+	if len(text) < 2 || text[0] != 'u' {
+		return os.NewError("user IDs start with u")
+	}
+	n, err := strconv.Atoi(string(text[1:]))
+	if err != nil {
+		return err
+	}
+	u.N = n
+	return nil
+}
+
+func (u testUserID) String() string { return "u" + strconv.Itoa(u.N) }
+
+type keyedObjectType struct {
+	Jobs  map[int]string
+	Flags map[bool]int
+	Rates map[float64]string
+	Users map[testUserID]string
+}
+
+var keyedObject = keyedObjectType{
+	Jobs:  map[int]string{1: "one"},
+	Flags: map[bool]int{true: 1},
+	Rates: map[float64]string{0.5: "half"},
+	Users: map[testUserID]string{testUserID{7}: "seven"},
+}
+
+var keyTests = []writeTest{
+	{"/keyed/jobs/1", "GET", "", http.StatusOK, "", "", `"one"`},
+	{"/keyed/jobs/x", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/keyed/jobs/2", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/keyed/jobs", "GET", "", http.StatusOK, "", "", `{"1":"one"}`},
+	{"/keyed/jobs/2", "PUT", `"two"`, http.StatusCreated, "Location", "/keyed/jobs/2", ""},
+	{"/keyed/jobs/1", "DELETE", "", http.StatusNoContent, "", "", ""},
+	{"/keyed/jobs", "POST", `{"3":"three"}`, http.StatusCreated, "Location", "/keyed/jobs/3", ""},
+	{"/keyed/jobs", "POST", `{"x":"ex"}`, http.StatusBadRequest, "", "", ""},
+	{"/keyed/jobs", "GET", "", http.StatusOK, "", "", `{"2":"two","3":"three"}`},
+	{"/keyed/jobs", "PUT", `{"4":"four"}`, http.StatusNoContent, "", "", ""},
+	{"/keyed/jobs", "GET", "", http.StatusOK, "", "", `{"4":"four"}`},
+	{"/keyed/flags/true", "GET", "", http.StatusOK, "", "", "1"},
+	{"/keyed/flags/false", "PUT", "0", http.StatusCreated, "Location", "/keyed/flags/false", ""},
+	{"/keyed/rates/0.5", "GET", "", http.StatusOK, "", "", `"half"`},
+	{"/keyed/users/u7", "GET", "", http.StatusOK, "", "", `"seven"`},
+	{"/keyed/users/7", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/keyed/users/u8", "PUT", `"eight"`, http.StatusCreated, "Location", "/keyed/users/u8", ""},
+	{"/keyed/users/u8", "GET", "", http.StatusOK, "", "", `"eight"`},
+}
+
+func TestKeys(t *testing.T) {
+	if _, err := Map("/keyed", &keyedObject); err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	runWriteTests(t, keyTests)
+}
+
+type testBackend struct {
+	Name string `rest:"key"`
+	Port int
+}
+
+// A testZone has a key which cannot be compared with ==.
+type testZone struct {
+	Names []string `rest:"key"`
+	Port  int
+}
+
+type backendsObjectType struct {
+	Backends []testBackend
+	Pointers []*testBackend
+	Zones    []testZone
+}
+
+var backendsObject = backendsObjectType{
+	Backends: []testBackend{{"east-1", 80}, {"west-1", 81}},
+}
+
+var slicekeyTests = []writeTest{
+	{"/backends/backends/east-1", "GET", "", http.StatusOK, "", "", `{"Name":"east-1","Port":80}`},
+	{"/backends/backends/1/port", "GET", "", http.StatusOK, "", "", "81"},
+	{"/backends/backends/west-1/port", "PUT", "82", http.StatusNoContent, "", "", ""},
+	{"/backends/backends/1", "GET", "", http.StatusOK, "", "", `{"Name":"west-1","Port":82}`},
+	{"/backends/backends/north-1", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/backends/backends/north-1", "PUT", `{"Port":83}`, http.StatusCreated, "Location", "/backends/backends/north-1", ""},
+	{"/backends/backends/2", "GET", "", http.StatusOK, "", "", `{"Name":"north-1","Port":83}`},
+	{"/backends/backends/north-1", "PUT", `{"Name":"south-1"}`, http.StatusBadRequest, "", "", "does not match"},
+	{"/backends/backends", "POST", `{"Name":"east-1"}`, http.StatusConflict, "", "", ""},
+	{"/backends/backends", "POST", `{"Name":"south-1","Port":84}`, http.StatusCreated, "Location", "/backends/backends/south-1", ""},
+	{"/backends/backends/east-1", "DELETE", "", http.StatusNoContent, "", "", ""},
+	{"/backends/backends/east-1", "GET", "", http.StatusNotFound, "", "", ""},
+	{"/backends/backends/0", "GET", "", http.StatusOK, "", "", `"west-1"`},
+	{"/backends/pointers/east-2", "PUT", `{"Port":90}`, http.StatusCreated, "Location", "/backends/pointers/east-2", ""},
+	{"/backends/pointers/east-2/port", "GET", "", http.StatusOK, "", "", "90"},
+	{"/backends/zones/east", "PUT", `{"Port":91}`, http.StatusBadRequest, "", "", "does not match"},
+}
+
+func TestSliceKeys(t *testing.T) {
+	if _, err := Map("/backends", &backendsObject); err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	runWriteTests(t, slicekeyTests)
+}
+
+var pageLog = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+var pageTests = []struct {
+	Query   string
+	Range   string
+	Code    int
+	Body    string
+	Content string
+	Links   []string
+}{
+	{"", "", http.StatusOK, "[0,1,2,3,4,5,6,7,8,9]", "", nil},
+	{"?offset=2&limit=3", "", http.StatusPartialContent, "[2,3,4]", "items 2-4/10", []string{
+		`</log/?limit=3&offset=5>; rel="next"`,
+		`</log/?limit=3&offset=0>; rel="prev"`,
+	}},
+	{"?limit=4", "", http.StatusPartialContent, "[0,1,2,3]", "items 0-3/10", []string{
+		`</log/?limit=4&offset=4>; rel="next"`,
+	}},
+	{"?offset=8", "", http.StatusPartialContent, "[8,9]", "items 8-9/10", []string{
+		`</log/?limit=2&offset=6>; rel="prev"`,
+	}},
+	{"?offset=8&limit=5", "", http.StatusPartialContent, "[8,9]", "items 8-9/10", []string{
+		`</log/?limit=2&offset=6>; rel="prev"`,
+	}},
+	{"", "items=5-6", http.StatusPartialContent, "[5,6]", "items 5-6/10", []string{
+		`</log/?limit=2&offset=7>; rel="next"`,
+		`</log/?limit=2&offset=3>; rel="prev"`,
+	}},
+	{"", "items=7-", http.StatusPartialContent, "[7,8,9]", "items 7-9/10", []string{
+		`</log/?limit=3&offset=4>; rel="prev"`,
+	}},
+	{"", "bytes=0-1", http.StatusOK, "[0,1,2,3,4,5,6,7,8,9]", "", nil},
+	{"?offset=10", "", http.StatusRequestedRangeNotSatisfiable, "", "items */10", nil},
+	{"", "items=3-1", http.StatusBadRequest, "", "", nil},
+	{"?limit=x", "", http.StatusBadRequest, "", "", nil},
+}
+
+func TestPage(t *testing.T) {
+	if _, err := Map("/log", &pageLog); err != nil {
+		t.Fatalf("map: %s", err)
+	}
+
+	for _, test := range pageTests {
+		desc := test.Query + " " + test.Range
+		r, err := http.NewRequest("GET", "/log/"+test.Query, nil)
+		if err != nil {
+			t.Errorf("%s - newrequest: %s", desc, err)
+			continue
+		}
+		if test.Range != "" {
+			r.Header.Set("Range", test.Range)
+		}
+		w := httptest.NewRecorder()
+
+		DefaultServeMux.ServeHTTP(w, r)
+		if got, want := w.Code, test.Code; got != want {
+			t.Errorf("%s - code = %v, want %v", desc, got, want)
+		}
+		if got, want := w.HeaderMap.Get("Content-Range"), test.Content; got != want {
+			t.Errorf("%s - content-range = %q, want %q", desc, got, want)
+		}
+		if got, want := w.HeaderMap["Link"], test.Links; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s - links = %q, want %q", desc, got, want)
+		}
+		if test.Code < 300 {
+			if got, want := w.Body.String(), test.Body; got != want {
+				t.Errorf("%s - body = %q, want %q", desc, got, want)
+			}
+		}
+	}
+}
+
+type testJob struct {
+	Name    string
+	Status  string
+	Started int
+	Stats   struct {
+		Retries int
+	}
+}
+
+type jobsObjectType struct {
+	List  []testJob
+	Ports map[string]int
+	Ints  []int
+	Mixed []interface{}
+}
+
+var jobsObject = jobsObjectType{
+	List: []testJob{
+		{Name: "a", Status: "running", Started: 3},
+		{Name: "b", Status: "stopped", Started: 1},
+		{Name: "c", Status: "running", Started: 2},
+		{Name: "d", Status: "starting", Started: 4},
+	},
+	Ports: map[string]int{"http": 80, "https": 443, "ssh": 22},
+	Ints:  []int{5, 3, 9, 1},
+	Mixed: []interface{}{
+		map[string]interface{}{"age": "x"},
+		map[string]interface{}{"age": 3},
+		map[string]interface{}{"age": true},
+	},
+}
+
+func init() {
+	jobsObject.List[2].Stats.Retries = 2
+}
+
+var queryTests = []struct {
+	Query string
+	Code  int
+	Body  string
+}{
+	{"list?filter=status==running", http.StatusOK, ""},
+	{"ints?sort=value", http.StatusOK, "[1,3,5,9]"},
+	{"ints?sort=-value", http.StatusOK, "[9,5,3,1]"},
+	{"ints?filter=value>=5", http.StatusOK, "[5,9]"},
+	{"ints?filter=value<2,value>8", http.StatusOK, "[9,1]"},
+	{"ints?filter=value>1&sort=value&limit=2", http.StatusPartialContent, "[3,5]"},
+	{"mixed?sort=age", http.StatusOK, `[{"age":true},{"age":3},{"age":"x"}]`},
+	{"mixed?sort=-age", http.StatusOK, `[{"age":"x"},{"age":3},{"age":true}]`},
+	{"ports?filter=key^=http", http.StatusOK, `{"http":80,"https":443}`},
+	{"ports?filter=value<100&filter=key~=s", http.StatusOK, `{"ssh":22}`},
+	{"ports?sort=key", http.StatusBadRequest, ""},
+	{"list?filter=status", http.StatusBadRequest, ""},
+	{"list?filter=name==%22a", http.StatusBadRequest, ""},
+	{"list?sort=-", http.StatusBadRequest, ""},
+}
+
+var queryNames = []struct {
+	Query string
+	Names []string
+}{
+	{"filter=status==running", []string{"a", "c"}},
+	{"filter=status==running&sort=-started", []string{"a", "c"}},
+	{"filter=status^=st&sort=name", []string{"b", "d"}},
+	{"filter=status~=run,started<2&sort=-name", []string{"c", "b", "a"}},
+	{"filter=stats.retries>0", []string{"c"}},
+	{"sort=status,-started", []string{"a", "c", "d", "b"}},
+	{"filter=started!=3&filter=started<=2&sort=started", []string{"b", "c"}},
+	{"filter=status==running&filter=started<3", []string{"c"}},
+	{"filter=status==%22running%22,name==%22b%22", []string{"a", "b", "c"}},
+	{"filter=status==%22running,stopped%22", nil},
+	{"filter=status==running%3Bstarted<3", nil},
+	{"filter=started==x", nil},
+}
+
+func TestQuery(t *testing.T) {
+	if _, err := Map("/jobs", &jobsObject); err != nil {
+		t.Fatalf("map: %s", err)
+	}
+
+	for _, test := range queryTests {
+		desc := test.Query
+		r, err := http.NewRequest("GET", "/jobs/"+test.Query, nil)
+		if err != nil {
+			t.Errorf("%s - newrequest: %s", desc, err)
+			continue
+		}
+		w := httptest.NewRecorder()
+
+		DefaultServeMux.ServeHTTP(w, r)
+		if got, want := w.Code, test.Code; got != want {
+			t.Errorf("%s - code = %v, want %v", desc, got, want)
+		}
+		if test.Code < 300 && test.Body != "" {
+			if got, want := w.Body.String(), test.Body; got != want {
+				t.Errorf("%s - body = %q, want %q", desc, got, want)
+			}
+		}
+	}
+
+	for _, test := range queryNames {
+		desc := test.Query
+		r, err := http.NewRequest("GET", "/jobs/list?"+test.Query, nil)
+		if err != nil {
+			t.Errorf("%s - newrequest: %s", desc, err)
+			continue
+		}
+		w := httptest.NewRecorder()
+
+		DefaultServeMux.ServeHTTP(w, r)
+		var jobs []testJob
+		if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil {
+			t.Errorf("%s - unmarshal: %s", desc, err)
+			continue
+		}
+		var names []string
+		for _, job := range jobs {
+			names = append(names, job.Name)
+		}
+		if got, want := names, test.Names; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s - names = %q, want %q", desc, got, want)
+		}
+	}
+}
+
+var fieldsTests = []struct {
+	Path string
+	Code int
+	Body string
+}{
+	{"/mutable/?fields=string", http.StatusOK, `{"String":"teststr"}`},
+	{"/mutable/?fields=STRING,map.true", http.StatusOK, `{"String":"teststr","Map":{"true":true}}`},
+	{"/mutable/?fields=numbers,numbers.x", http.StatusOK, `{"Numbers":[6,9,42]}`},
+	{"/mutable/?depth=1", http.StatusOK,
+		`{"String":"teststr","Numbers":{"href":"/mutable/numbers"},"Map":{"href":"/mutable/map"}}`},
+	{"/mutable/?depth=0", http.StatusOK, `{"href":"/mutable/"}`},
+	{"/mutable/?depth=x", http.StatusBadRequest, ""},
+	{"/mutable/?filter=string==x", http.StatusBadRequest, ""},
+	{"/jobs/list?fields=name&filter=status==running", http.StatusOK, `[{"Name":"a"},{"Name":"c"}]`},
+	{"/jobs/list?depth=1&filter=status==running", http.StatusOK,
+		`[{"href":"/jobs/list/0"},{"href":"/jobs/list/2"}]`},
+	{"/jobs/list?depth=2&fields=stats&sort=-started&limit=1", http.StatusPartialContent,
+		`[{"Stats":{"href":"/jobs/list/3/stats"}}]`},
+	{"/jobs/?fields=ports.ssh,ints", http.StatusOK, `{"Ports":{"ssh":22},"Ints":[5,3,9,1]}`},
+}
+
+func TestFields(t *testing.T) {
+	for _, test := range fieldsTests {
+		desc := test.Path
+		r, err := http.NewRequest("GET", test.Path, nil)
+		if err != nil {
+			t.Errorf("%s - newrequest: %s", desc, err)
+			continue
+		}
+		w := httptest.NewRecorder()
+
+		DefaultServeMux.ServeHTTP(w, r)
+		if got, want := w.Code, test.Code; got != want {
+			t.Errorf("%s - code = %v, want %v", desc, got, want)
+		}
+		if test.Code < 300 {
+			if got, want := w.Body.String(), test.Body; got != want {
+				t.Errorf("%s - body = %q, want %q", desc, got, want)
+			}
+		}
+	}
+}
+
+var halTests = []struct {
+	Path   string
+	Accept string
+	Code   int
+	Type   string
+	Body   string
+}{
+	{"/mutable/?fields=string", "application/hal+json", http.StatusOK, "application/hal+json",
+		`{"_links":{"self":{"href":"/mutable/"},"String":{"href":"/mutable/string"}},"String":"teststr"}`},
+	{"/mutable/?fields=numbers", "application/hal+json", http.StatusOK, "application/hal+json",
+		`{"_links":{"self":{"href":"/mutable/"},"Numbers":{"href":"/mutable/numbers"}},` +
+			`"Numbers":{"_links":{"self":{"href":"/mutable/numbers"},"item":[{"href":"/mutable/numbers/0"},` +
+			`{"href":"/mutable/numbers/1"},{"href":"/mutable/numbers/2"}]},"_embedded":{"item":[6,9,42]}}}`},
+	{"/mutable/?depth=0", "application/hal+json", http.StatusOK, "application/hal+json",
+		`{"_links":{"self":{"href":"/mutable/"}}}`},
+	{"/jobs/?fields=ports.ssh", "application/hal+json", http.StatusOK, "application/hal+json",
+		`{"_links":{"self":{"href":"/jobs/"},"Ports":{"href":"/jobs/ports"}},` +
+			`"Ports":{"_links":{"self":{"href":"/jobs/ports"},"ssh":{"href":"/jobs/ports/ssh"}},"ssh":22}}`},
+	{"/jobs/list?depth=1&filter=status==running", "application/hal+json", http.StatusOK, "application/hal+json",
+		`{"_links":{"self":{"href":"/jobs/list"},"item":[{"href":"/jobs/list/0"},{"href":"/jobs/list/2"}]},` +
+			`"_embedded":{"item":[{"_links":{"self":{"href":"/jobs/list/0"}}},{"_links":{"self":{"href":"/jobs/list/2"}}}]}}`},
+	{"/mutable/string", "application/hal+json", http.StatusOK, "application/hal+json", `"teststr"`},
+	{"/mutable/string", "application/hal+json;q=0.5, application/json;q=0.1", http.StatusOK, "application/hal+json", `"teststr"`},
+	{"/mutable/string", "application/json", http.StatusOK, "application/json", `"teststr"`},
+	{"/mutable/string", "*/*", http.StatusOK, "application/json", `"teststr"`},
+	{"/mutable/string", "text/html", http.StatusNotAcceptable, "", ""},
+}
+
+func TestHAL(t *testing.T) {
+	for _, test := range halTests {
+		desc := test.Path + " (" + test.Accept + ")"
+		r, err := http.NewRequest("GET", test.Path, nil)
+		if err != nil {
+			t.Errorf("%s - newrequest: %s", desc, err)
+			continue
+		}
+		r.Header.Set("Accept", test.Accept)
+		w := httptest.NewRecorder()
+
+		DefaultServeMux.ServeHTTP(w, r)
+		if got, want := w.Code, test.Code; got != want {
+			t.Errorf("%s - code = %v, want %v", desc, got, want)
+		}
+		if test.Code < 300 {
+			if got, want := w.HeaderMap.Get("Content-Type"), test.Type; got != want {
+				t.Errorf("%s - content-type = %q, want %q", desc, got, want)
+			}
+			if got, want := w.Body.String(), test.Body; got != want {
+				t.Errorf("%s - body = %q, want %q", desc, got, want)
+			}
+		}
+	}
+}
+
+func TestHALDecode(t *testing.T) {
+	hal := LookupCodec("application/hal+json; charset=utf-8")
+	if hal == nil {
+		t.Fatalf("no codec for application/hal+json")
+	}
+
+	var list []int
+	js := `{"_links":{"self":{"href":"/l"}},"_embedded":{"item":[1,2,3]}}`
+	if err := hal.Decode([]byte(js), &list); err != nil {
+		t.Errorf("decode list: %s", err)
+	}
+	if got, want := list, []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decode list = %v, want %v", got, want)
+	}
+
+	var m map[string]int
+	js = `{"_links":{"self":{"href":"/m"}},"a":1}`
+	if err := hal.Decode([]byte(js), &m); err != nil {
+		t.Errorf("decode map: %s", err)
+	}
+	if got, want := m, map[string]int{"a": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decode map = %v, want %v", got, want)
+	}
+}
+
+var indexTests = []writeTest{
+	{"/_rest/", "GET", "", http.StatusOK, "Content-Type", "application/json",
+		`{"Path":"/int/","Type":"int","Kind":"int","ReadOnly":false,"Methods":["OPTIONS","HEAD","GET","PUT"]}`},
+	{"/_rest/", "GET", "", http.StatusOK, "", "",
+		`{"Path":"/readonly/","Type":"rest.testObjectType","Kind":"struct","ReadOnly":true,"Methods":["OPTIONS","HEAD","GET"]}`},
+	{"/_rest/", "GET", "", http.StatusOK, "", "",
+		`{"Path":"/error/auth","Type":"rest.errorResponder","Kind":"","ReadOnly":false,"Methods":null}`},
+	{"/_rest/?fields=path&limit=1", "GET", "", http.StatusPartialContent, "", "", `[{"Path":"/backends/"}]`},
+	{"/_rest/0/path", "GET", "", http.StatusOK, "", "", `"/backends/"`},
+	{"/_rest/", "POST", "{}", http.StatusMethodNotAllowed, "Allow", "OPTIONS, HEAD, GET", ""},
+}
+
+func TestIndex(t *testing.T) {
+	runWriteTests(t, indexTests)
+}
+
+var openapiTests = []struct {
+	Path    string
+	Methods string
+}{
+	{"/mutable/", "get patch put"},
+	{"/readonly/", "get"},
+	{"/readonly/map/{key}", "get"},
+	{"/mutable/numbers/{index}", "delete get put"},
+	{"/jobs/list/{index}/stats/retries", "get put"},
+	{"/jobs/ports/{key}", "delete get put"},
+	{"/counter/total", "get post"},
+	{"/counter/add", "post"},
+}
+
+func TestOpenAPI(t *testing.T) {
+	r, err := http.NewRequest("GET", OpenAPIPath, nil)
+	if err != nil {
+		t.Fatalf("newrequest: %s", err)
+	}
+	w := httptest.NewRecorder()
+
+	DefaultServeMux.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("code = %v, want %v", got, want)
+	}
+
+	var doc struct {
+		OpenAPI    string
+		Paths      map[string]map[string]interface{}
+		Components struct {
+			Schemas map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+	if got, want := doc.OpenAPI, "3.1.0"; got != want {
+		t.Errorf("openapi = %q, want %q", got, want)
+	}
+	for _, name := range []string{"rest.testObjectType", "rest.testJob"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("no schema for %s", name)
+		}
+	}
+
+	for _, test := range openapiTests {
+		item, ok := doc.Paths[test.Path]
+		if !ok {
+			t.Errorf("%s - not described", test.Path)
+			continue
+		}
+		var methods []string
+		for method := range item {
+			if method != "parameters" {
+				methods = append(methods, method)
+			}
+		}
+		sort.Strings(methods)
+		if got, want := strings.Join(methods, " "), test.Methods; got != want {
+			t.Errorf("%s - methods = %q, want %q", test.Path, got, want)
+		}
+	}
+
+	get := doc.Paths["/mutable/numbers/{index}"]["get"].(map[string]interface{})
+	if _, ok := get["responses"].(map[string]interface{})["404"]; !ok {
+		t.Errorf("/mutable/numbers/{index} - no 404 response")
+	}
+}
+
+var schemaTests = []writeTest{
+	{"/write/?schema", "GET", "", http.StatusOK, "Content-Type", SchemaType,
+		`"$ref":"#/$defs/rest.testObjectType"`},
+	{"/write/?schema", "GET", "", http.StatusOK, "", "",
+		`"String":{"type":"string"}`},
+	{"/write/numbers?schema", "GET", "", http.StatusOK, "", "",
+		`{"$schema":"https://json-schema.org/draft/2020-12/schema","items":{"type":"integer"},"type":["array","null"]}`},
+	{"/write/", "PUT", `{"String":5,"Numbers":[1,"x"],"Map":{"a":1}}`, http.StatusBadRequest, "", "",
+		`"/Map/a": got integer, want boolean`},
+	{"/write/", "PUT", `{"String":5,"Numbers":[1,"x"],"Map":{"a":1}}`, http.StatusBadRequest, "", "",
+		`"/Numbers/1": got string, want integer`},
+	{"/write/", "PUT", `{"String":5,"Numbers":[1,"x"],"Map":{"a":1}}`, http.StatusBadRequest, "", "",
+		`"/String": got integer, want string`},
+	{"/write/", "PUT", `{"string":5,"numbers":"x"}`, http.StatusBadRequest, "", "",
+		`"/numbers": got string, want array or null`},
+	{"/write/", "PUT", `{"string":5,"numbers":"x"}`, http.StatusBadRequest, "", "",
+		`"/string": got integer, want string`},
+	{"/write/numbers/0", "PUT", `1.5`, http.StatusBadRequest, "", "", `"": got number, want integer`},
+	{"/write/numbers/0", "PUT", `7`, http.StatusNoContent, "", "", ""},
+}
+
+func TestSchema(t *testing.T) {
+	runWriteTests(t, schemaTests)
+
+	r, err := http.NewRequest("GET", "/write/string", nil)
+	if err != nil {
+		t.Fatalf("newrequest: %s", err)
+	}
+	r.Header.Set("Accept", SchemaType)
+	w := httptest.NewRecorder()
+
+	DefaultServeMux.ServeHTTP(w, r)
+	if got, want := w.Body.String(), `{"$schema":"https://json-schema.org/draft/2020-12/schema","type":"string"}`; got != want {
+		t.Errorf("accept schema - body = %q, want %q", got, want)
+	}
+}
+
+var patchTests = []writeTest{
+	{"/write/", "PATCH", `{"String":"patched","Map":{"a":true,"b":true}}`, http.StatusNoContent, "", "", ""},
+	{"/write/", "GET", "", http.StatusOK, "", "", `{"String":"patched","Numbers":[7,5],"Map":{"a":true,"b":true}}`},
+	{"/write/map", "PATCH", `{"a":null}`, http.StatusNoContent, "", "", ""},
+	{"/write/map", "GET", "", http.StatusOK, "", "", `{"b":true}`},
+	{"/write/", "PATCH", `{"string":"lower"}`, http.StatusNoContent, "", "", ""},
+	{"/write/string", "GET", "", http.StatusOK, "", "", `"lower"`},
+	{"/write/", "PATCH", `{"String":1}`, http.StatusBadRequest, "", "", `"/String": got integer, want string`},
+	{"/write/", "PATCH", `{`, http.StatusBadRequest, "", "", ""},
+	{"/write/numbers", "PATCH", `[1]`, http.StatusMethodNotAllowed, "", "", ""},
+}
+
+func TestPatch(t *testing.T) {
+	runWriteTests(t, patchTests)
+}
+
+// request serves a request with the given headers and returns the recorded
+// response.
+func request(t *testing.T, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("%s %s - newrequest: %s", method, path, err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	DefaultServeMux.ServeHTTP(w, r)
+	return w
+}
+
+func TestETag(t *testing.T) {
+	w := request(t, "GET", "/write/string", "")
+	tag := w.HeaderMap.Get("ETag")
+	if tag == "" {
+		t.Fatalf("GET - no etag")
+	}
+
+	if w := request(t, "GET", "/write/string", "", "If-None-Match", tag); w.Code != http.StatusNotModified {
+		t.Errorf("GET if-none-match - code = %v, want %v", w.Code, http.StatusNotModified)
+	}
+	if w := request(t, "PUT", "/write/string", `"x"`, "If-Match", `"stale"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT stale if-match - code = %v, want %v", w.Code, http.StatusPreconditionFailed)
+	}
+
+	w = request(t, "PUT", "/write/string", `"tagged"`, "If-Match", tag)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("PUT if-match - code = %v, want %v", w.Code, http.StatusNoContent)
+	}
+	if got := w.HeaderMap.Get("ETag"); got == "" || got == tag {
+		t.Errorf("PUT if-match - etag = %q, want a new tag", got)
+	}
+	if w := request(t, "PUT", "/write/string", `"again"`, "If-Match", tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT old if-match - code = %v, want %v", w.Code, http.StatusPreconditionFailed)
+	}
+
+	if w := request(t, "PUT", "/write/map/c", `true`, "If-None-Match", "*"); w.Code != http.StatusCreated {
+		t.Errorf("PUT new if-none-match - code = %v, want %v", w.Code, http.StatusCreated)
+	}
+	if w := request(t, "PUT", "/write/map/c", `true`, "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT existing if-none-match - code = %v, want %v", w.Code, http.StatusPreconditionFailed)
+	}
+
+	// Each representation of a value has its own tag
+	full := request(t, "GET", "/jobs/list", "").HeaderMap.Get("ETag")
+	w = request(t, "GET", "/jobs/list?fields=name", "", "If-None-Match", full)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET fields if-none-match full - code = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.HeaderMap.Get("ETag"); got == "" || got == full {
+		t.Errorf("GET fields - etag = %q, want a tag other than %q", got, full)
+	}
+	if w := request(t, "GET", "/jobs/list", "", "Accept", "application/hal+json"); w.HeaderMap.Get("ETag") == full {
+		t.Errorf("GET hal - etag = %q, want a tag other than that of JSON", full)
+	}
+}
+
+func TestProblem(t *testing.T) {
+	w := request(t, "GET", "/write/nothing", "", "Accept", "application/json, "+ProblemType)
+	if got, want := w.HeaderMap.Get("Content-Type"), ProblemType; got != want {
+		t.Fatalf("GET - content-type = %q, want %q", got, want)
+	}
+	p := new(Problem)
+	if err := json.Unmarshal(w.Body.Bytes(), p); err != nil {
+		t.Fatalf("GET - unmarshal: %s", err)
+	}
+	if got, want := p.Status, http.StatusNotFound; got != want {
+		t.Errorf("GET - status = %v, want %v", got, want)
+	}
+	if err, ok := p.Err().(*BadSub); !ok || err.ResURI != "/write/" || err.SubURI != "nothing" {
+		t.Errorf("GET - err = %#v, want BadSub", p.Err())
+	}
+
+	w = request(t, "DELETE", "/write/", "", "Accept", ProblemType)
+	p = new(Problem)
+	if err := json.Unmarshal(w.Body.Bytes(), p); err != nil {
+		t.Fatalf("DELETE - unmarshal: %s", err)
+	}
+	err, ok := p.Err().(*BadMethod)
+	if !ok {
+		t.Fatalf("DELETE - err = %#v, want BadMethod", p.Err())
+	}
+	if got, want := strings.Join(err.AllowedMethods(), ", "), "OPTIONS, HEAD, GET, PATCH, PUT"; got != want {
+		t.Errorf("DELETE - allowed = %q, want %q", got, want)
+	}
+
+	w = request(t, "GET", "/write/nothing", "")
+	if got := w.HeaderMap.Get("Content-Type"); got == ProblemType {
+		t.Errorf("GET without accept - content-type = %q", got)
+	}
+}
+
+type persistObjectType struct {
+	Name   string
+	Ports  map[string]int
+	secret string
+}
+
+var persistObject = persistObjectType{
+	Name:   "default",
+	Ports:  map[string]int{"http": 80},
+	secret: "kept",
+}
+
+func TestSnapshot(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "rest_test_persist.json")
+	defer os.Remove(file)
+	if err := ioutil.WriteFile(file, []byte(`{"Name":"saved","Ports":{"https":443}}`), 0666); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	res, err := Map("/persist", &persistObject)
+	if err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	if err := res.Persist(file); err != nil {
+		t.Fatalf("persist: %s", err)
+	}
+	want := persistObjectType{"saved", map[string]int{"https": 443}, "kept"}
+	if got := persistObject; !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded = %#v, want %#v", got, want)
+	}
+
+	if w := request(t, "PUT", "/persist/ports/http", "8080"); w.Code != http.StatusCreated {
+		t.Errorf("PUT - code = %v, want %v", w.Code, http.StatusCreated)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	var saved persistObjectType
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("unmarshal %q: %s", data, err)
+	}
+	want.Ports["http"] = 8080
+	if got := saved; !reflect.DeepEqual(got, persistObjectType{Name: want.Name, Ports: want.Ports}) {
+		t.Errorf("saved = %#v, want %#v", got, want)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := res.Snapshot(buf); err != nil {
+		t.Fatalf("snapshot: %s", err)
+	}
+	var snap persistObjectType
+	if err := json.Unmarshal(buf.Bytes(), &snap); err != nil {
+		t.Fatalf("unmarshal %q: %s", buf.Bytes(), err)
+	}
+	if got := snap; !reflect.DeepEqual(got, persistObjectType{Name: want.Name, Ports: want.Ports}) {
+		t.Errorf("snapshot = %#v, want %#v", got, want)
+	}
+
+	if err := res.Restore(strings.NewReader(`{"Name":"restored"}`)); err != nil {
+		t.Fatalf("restore: %s", err)
+	}
+	want = persistObjectType{Name: "restored", secret: "kept"}
+	if got := persistObject; !reflect.DeepEqual(got, want) {
+		t.Errorf("restored = %#v, want %#v", got, want)
+	}
+	if err := res.Restore(strings.NewReader(`[1]`)); err == nil {
+		t.Errorf("restore of a list succeeded")
+	}
+
+	// A change which cannot be saved is reported as a failure
+	dir := filepath.Join(os.TempDir(), "rest_test_persist")
+	os.RemoveAll(dir)
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	if err := res.Persist(filepath.Join(dir, "value.json")); err != nil {
+		t.Fatalf("persist: %s", err)
+	}
+	os.RemoveAll(dir)
+	version := res.version
+	for _, test := range []struct{ Path, Body string }{
+		{"/persist/name", `"unsaved"`},
+		{"/persist/ports/smtp", `25`},
+	} {
+		if w := request(t, "PUT", test.Path, test.Body); w.Code != http.StatusInternalServerError {
+			t.Errorf("unsaved PUT %s - code = %v, want %v", test.Path, w.Code, http.StatusInternalServerError)
+		}
+		if got := persistObject; !reflect.DeepEqual(got, want) || res.version != version {
+			t.Errorf("after unsaved PUT %s = %#v (version %d), want %#v (version %d)",
+				test.Path, got, res.version, want, version)
+		}
+	}
+	if w := request(t, "GET", "/persist/name", ""); w.Body.String() != `"restored"` {
+		t.Errorf("GET after unsaved PUT = %s, want %q", w.Body, "restored")
+	}
+}
+
+type journalObjectType struct {
+	Tags     []string
+	Ports    map[string]int
+	Restarts int
+}
+
+var journalObject journalObjectType
+
+// journalRestarts counts the calls of Restart, which must not be replayed.
+var journalRestarts int
+
+func (j *journalObjectType) Restart() {
+	j.Restarts++
+	journalRestarts++
+}
+
+func TestJournal(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "rest_test_journal")
+	os.Remove(file)
+	os.Remove(file + ".snapshot")
+	defer os.Remove(file)
+	defer os.Remove(file + ".snapshot")
+	defer func(n int) { JournalCompact = n }(JournalCompact)
+	JournalCompact = 3
+
+	res, err := Map("/journal", &journalObject)
+	if err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	if err := res.Journal(file); err != nil {
+		t.Fatalf("journal: %s", err)
+	}
+
+	for _, test := range []struct{ Method, Path, Body string }{
+		{"POST", "/journal/tags", `"a"`},
+		{"PUT", "/journal/ports/http", `80`},
+		{"POST", "/journal/tags", `"b"`},
+		{"DELETE", "/journal/tags/0", ``},
+		{"PATCH", "/journal/ports", `{"https":443}`},
+		{"POST", "/journal/restart", ``},
+	} {
+		if w := request(t, test.Method, test.Path, test.Body); w.Code >= 300 {
+			t.Errorf("%s %s - code = %v: %s", test.Method, test.Path, w.Code, w.Body)
+		}
+	}
+
+	// A new resource at the same path is restored from the snapshot and journal
+	replay := func() journalObjectType {
+		var obj journalObjectType
+		res := &Resource{
+			path:  "/journal/",
+			kind:  reflect.Struct,
+			value: reflect.ValueOf(&obj).Elem(),
+		}
+		if err := res.Journal(file); err != nil {
+			t.Fatalf("replay: %s", err)
+		}
+		res.journal.file.Close()
+		return obj
+	}
+	want := journalObjectType{
+		Tags:     []string{"b"},
+		Ports:    map[string]int{"http": 80, "https": 443},
+		Restarts: 1,
+	}
+	if got := replay(); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed = %#v, want %#v", got, want)
+	}
+	if got, want := journalRestarts, 1; got != want {
+		t.Errorf("restarts after replay = %d, want %d", got, want)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	f.WriteString(`00000000 {"Version":7,"Method":"DELETE","Path":"/journal/ports"`)
+	f.Close()
+
+	if got := replay(); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed with corrupt tail = %#v, want %#v", got, want)
+	}
+	if data, err := ioutil.ReadFile(file); err != nil {
+		t.Errorf("read: %s", err)
+	} else if strings.Contains(string(data), `"Version":7`) {
+		t.Errorf("corrupt tail not truncated: %q", data)
+	}
+
+	// A change which cannot be journaled is undone
+	res.journal.file.Close()
+	defer func() { res.journal = nil }()
+	version := res.version
+	if w := request(t, "PUT", "/journal/ports/http", "81"); w.Code != http.StatusInternalServerError {
+		t.Errorf("unjournaled PUT - code = %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+	if got := journalObject; !reflect.DeepEqual(got, want) || res.version != version {
+		t.Errorf("after unjournaled PUT = %#v (version %d), want %#v (version %d)",
+			got, res.version, want, version)
+	}
+}
+
+type historyObjectType struct {
+	Level int
+	Ports map[string]int
+	owner string
+}
+
+var historyObject = historyObjectType{
+	Level: 1,
+	Ports: map[string]int{"http": 80},
+	owner: "ops",
+}
+
+var historyTests = []writeTest{
+	{"/history/level", "PUT", `2`, 204, "", "", ""},
+	{"/history/ports/https", "PUT", `443`, 201, "", "", ""},
+	{"/history/?history", "GET", ``, 200, "Content-Type", "application/json", `{"Version":2,`},
+	{"/history/level?version=0", "GET", ``, 200, "", "", `1`},
+	{"/history/ports?version=1", "GET", ``, 200, "", "", `{"http":80}`},
+	{"/history/?diff=0", "GET", ``, 200, "Content-Type", PatchType,
+		`[{"op":"replace","path":"/level","value":2},{"op":"add","path":"/ports/https","value":443}]`},
+	{"/history/?diff=2&to=0", "GET", ``, 200, "", "",
+		`[{"op":"replace","path":"/level","value":1},{"op":"remove","path":"/ports/https"}]`},
+	{"/history/ports?diff=1", "GET", ``, 200, "", "", `[{"op":"add","path":"/https","value":443}]`},
+	{"/history/?version=9", "GET", ``, 404, "", "", ``},
+	{"/history/?version=x", "GET", ``, 400, "", "", ``},
+	{"/history/?rollback=0", "POST", ``, 204, "", "", ``},
+	{"/history/", "GET", ``, 200, "", "", `{"Level":1,"Ports":{"http":80}}`},
+	{"/history/?diff=3", "GET", ``, 200, "", "", `[]`},
+}
+
+func TestHistory(t *testing.T) {
+	res, err := Map("/history", &historyObject)
+	if err != nil {
+		t.Fatalf("map: %s", err)
+	}
+	if w := request(t, "GET", "/history/?history", ""); w.Body.String() != "[]" {
+		t.Errorf("history before KeepHistory = %s, want []", w.Body)
+	}
+	res.KeepHistory(10)
+	runWriteTests(t, historyTests)
+
+	want := historyObjectType{1, map[string]int{"http": 80}, "ops"}
+	if got := historyObject; !reflect.DeepEqual(got, want) {
+		t.Errorf("rolled back = %#v, want %#v", got, want)
+	}
+}
+
+var diffTests = []writeTest{
+	{"/history/?diff", "POST", `{"Level":5,"Ports":{"http":80,"ssh":22}}`, 200, "Content-Type", PatchType,
+		`[{"op":"replace","path":"/level","value":5},{"op":"add","path":"/ports/ssh","value":22}]`},
+	{"/history/ports?diff", "POST", `{"http":8080}`, 200, "", "", `[{"op":"replace","path":"/http","value":8080}]`},
+	{"/history/ports/ftp?diff", "POST", `21`, 200, "", "", `[{"op":"add","path":"","value":21}]`},
+	{"/history/level?diff", "POST", `1`, 200, "", "", `[]`},
+	{"/history/level?diff", "POST", `"high"`, 400, "", "", ``},
+	{"/history/missing/deeper?diff", "POST", `1`, 404, "", "", ``},
+	{"/readonly/string?diff", "POST", `"x"`, 200, "", "", `[{"op":"replace","path":"","value":"x"}]`},
+	{"/readonly/string", "GET", ``, 200, "", "", `"teststr"`},
+	{"/backends/backends?diff", "POST",
+		`[{"Name":"west-1","Port":82},{"Name":"mid-1","Port":1},{"Name":"north-1","Port":83},{"Name":"south-1","Port":84}]`,
+		200, "", "", `[{"op":"add","path":"/mid-1","value":{"Name":"mid-1","Port":1}}]`},
+	{"/backends/backends?diff", "POST", `[{"Name":"north-1","Port":85}]`, 200, "", "",
+		`[{"op":"remove","path":"/west-1"},{"op":"replace","path":"/north-1/port","value":85},{"op":"remove","path":"/south-1"}]`},
+}
+
+func TestDiff(t *testing.T) {
+	runWriteTests(t, diffTests)
+
+	want := historyObjectType{1, map[string]int{"http": 80}, "ops"}
+	if got := historyObject; !reflect.DeepEqual(got, want) {
+		t.Errorf("after diff = %#v, want %#v", got, want)
+	}
+}
+
+var dryRunTests = []writeTest{
+	{"/history/level?dry_run=true", "PUT", `7`, 204, "", "", ``},
+	{"/history/ports/smtp?dry_run", "PUT", `25`, 201, "Location", "/history/ports/smtp", ``},
+	{"/history/ports/http?dry_run=1", "DELETE", ``, 204, "", "", ``},
+	{"/history/ports?dry_run=true", "PATCH", `{"http":null}`, 204, "", "", ``},
+	{"/history/level?dry_run=true", "PUT", `"high"`, 400, "", "", ``},
+	{"/history/level?dry_run=yes", "PUT", `7`, 400, "", "", `invalid dry_run`},
+	{"/history/?rollback=1&dry_run=true", "POST", ``, 204, "", "", ``},
+	{"/history/level?dry_run=false", "GET", ``, 200, "", "", `1`},
+	{"/counter/step?dry_run=true", "POST", `2`, 400, "", "", ``},
+	{"/counter/add?dry_run=true", "POST", `5`, 400, "", "", ``},
+	{"/chan/send/?dry_run=true", "POST", `1`, 400, "", "", ``},
+	{"/queue/limit?dry_run=true", "PUT", `4`, 400, "", "", `computed properties`},
+}
+
+func TestDryRun(t *testing.T) {
+	runWriteTests(t, dryRunTests)
+
+	if w := request(t, "PUT", "/history/level", `9`, "Prefer", "dry-run"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT prefer dry-run - code = %v, want %v", w.Code, http.StatusNoContent)
+	} else if got, want := w.HeaderMap.Get("Preference-Applied"), "dry-run"; got != want {
+		t.Errorf("PUT prefer dry-run - Preference-Applied = %q, want %q", got, want)
+	}
+
+	want := historyObjectType{1, map[string]int{"http": 80}, "ops"}
+	if got := historyObject; !reflect.DeepEqual(got, want) {
+		t.Errorf("after dry runs = %#v, want %#v", got, want)
+	}
+	if w := request(t, "GET", "/history/?diff=3", ""); w.Body.String() != "[]" {
+		t.Errorf("history after dry runs - diff = %s, want []", w.Body)
+	}
+}
+
+type accountObjectType struct {
+	Owner   string
+	Balance int
+	Tags    map[string]string `json:",omitempty"`
+}
+
+var (
+	checkingObject = accountObjectType{"alice", 100, map[string]string{"kind": "current"}}
+	savingsObject  = accountObjectType{"alice", 100, nil}
+)
+
+var batchTests = []writeTest{
+	{"/_rest/batch", "GET", ``, 405, "Allow", "OPTIONS, POST", ``},
+	{"/_rest/batch", "POST", `{`, 400, "", "", ``},
+	{"/_rest/batch", "POST", `[{"Method":"GET","Path":"/nowhere/"}]`, 400, "", "", ``},
+	{"/_rest/batch", "POST", `[{"Method":"PUT","Path":"/readonly/value","Body":1}]`, 403, "", "", ``},
+	{"/_rest/batch", "POST", `[{"Method":"POST","Path":"/chan/send/","Body":1}]`, 400, "", "", `"Committed":false`},
+	{"/_rest/batch", "POST", `[{"Method":"POST","Path":"/counter/reset"}]`, 400, "", "", `"Committed":false`},
+	{"/_rest/batch", "POST", `[{"Method":"GET","Path":"/chan/"}]`, 400, "", "", `"Committed":false`},
+	{"/_rest/batch", "POST", `[{"Method":"PUT","Path":"/queue/limit","Body":3}]`, 400, "", "", `"Committed":false`},
+	{"/_rest/batch", "POST", `[` +
+		`{"Method":"PUT","Path":"/checking/balance","Body":50},` +
+		`{"Method":"PUT","Path":"/savings/balance","Body":150},` +
+		`{"Method":"GET","Path":"/checking/"}]`, 200, "Content-Type", "application/json",
+		`{"Committed":true,"Results":[{"Status":204,"ETag":"`},
+	{"/_rest/batch", "POST", `[` +
+		`{"Method":"PUT","Path":"/checking/balance","Body":0},` +
+		`{"Method":"PUT","Path":"/savings/balance","Body":"all"},` +
+		`{"Method":"GET","Path":"/checking/"}]`, 400, "", "",
+		`{"Committed":false,"Results":[{"Status":204,`},
+	{"/_rest/batch", "POST", `[` +
+		`{"Method":"PUT","Path":"/checking/tags/new","Body":"x"},` +
+		`{"Method":"DELETE","Path":"/checking/tags/kind"},` +
+		`{"Method":"PUT","Path":"/savings/tags/kind","Body":"savings"},` +
+		`{"Method":"PUT","Path":"/savings/balance","Body":"all"}]`, 400, "", "",
+		`{"Committed":false,"Results":[{"Status":201,`},
+	{"/checking/", "GET", ``, 200, "", "", `{"Owner":"alice","Balance":50,"Tags":{"kind":"current"}}`},
+	{"/savings/", "GET", ``, 200, "", "", `{"Owner":"alice","Balance":150}`},
+}
+
+func TestBatch(t *testing.T) {
+	if _, err := Map("/checking", &checkingObject); err != nil {
+		t.Fatalf("map checking: %s", err)
+	}
+	savings, err := Map("/savings", &savingsObject)
+	if err != nil {
+		t.Fatalf("map savings: %s", err)
+	}
+	tags := reflect.ValueOf(checkingObject.Tags).Pointer()
+	runWriteTests(t, batchTests)
+
+	// The values are modified in place, not replaced by copies
+	if reflect.ValueOf(checkingObject.Tags).Pointer() != tags {
+		t.Errorf("batch - checking tags were replaced")
+	}
+
+	w := request(t, "POST", "/_rest/batch", `[`+
+		`{"Method":"PATCH","Path":"/savings/","Body":{"Owner":"bob"}},`+
+		`{"Method":"GET","Path":"/savings/owner"},`+
+		`{"Method":"GET","Path":"/checking/balance"}]`)
+	var result BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("batch - unmarshal %q: %s", w.Body, err)
+	}
+	if !result.Committed || len(result.Results) != 3 {
+		t.Fatalf("batch - result = %+v, want 3 committed results", result)
+	}
+	for i, want := range []string{"", `"bob"`, `50`} {
+		got := ""
+		if body := result.Results[i].Body; body != nil {
+			got = string(*body)
+		}
+		if got != want {
+			t.Errorf("batch - result %d body = %q, want %q", i, got, want)
+		}
+	}
+	if got, want := savingsObject.Owner, "bob"; got != want {
+		t.Errorf("batch - savings owner = %q, want %q", got, want)
+	}
+
+	// If one resource cannot be saved, none of the batch is committed
+	dir := filepath.Join(os.TempDir(), "rest_test_batch")
+	os.RemoveAll(dir)
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	if err := savings.Persist(filepath.Join(dir, "savings.json")); err != nil {
+		t.Fatalf("persist: %s", err)
+	}
+	os.RemoveAll(dir)
+	defer func() { savings.file = "" }()
+	version := savings.version
+	if w := request(t, "POST", "/_rest/batch", `[`+
+		`{"Method":"PUT","Path":"/checking/balance","Body":0},`+
+		`{"Method":"PUT","Path":"/savings/balance","Body":200}]`); w.Code != http.StatusInternalServerError {
+		t.Errorf("unsaved batch - code = %v, want %v", w.Code, http.StatusInternalServerError)
+	} else if !strings.Contains(w.Body.String(), `"Committed":false`) || strings.Contains(w.Body.String(), `"Saved"`) {
+		t.Errorf("unsaved batch - body = %s, want nothing committed or saved", w.Body)
+	}
+	if checkingObject.Balance != 50 || savingsObject.Balance != 150 || savings.version != version {
+		t.Errorf("unsaved batch - balances = %d, %d (version %d), want 50, 150 (version %d)",
+			checkingObject.Balance, savingsObject.Balance, savings.version, version)
+	}
+}
+
 var optionsTests = []struct {
 	Path  string
 	Allow string
 }{
-	{"/mutable/", "OPTIONS, HEAD, GET, POST, PATCH, PUT, DELETE"},
+	{"/mutable/", "OPTIONS, HEAD, GET, PATCH, PUT"},
+	{"/mutable/numbers", "OPTIONS, HEAD, GET, POST, PUT"},
+	{"/mutable/map", "OPTIONS, HEAD, GET, POST, PATCH, PUT"},
+	{"/mutable/numbers/0", "OPTIONS, HEAD, GET, PUT, DELETE"},
+	{"/mutable/map/true", "OPTIONS, HEAD, GET, PUT, DELETE"},
 	{"/readonly/", "OPTIONS, HEAD, GET"},
+	{"/readonly/numbers/0", "OPTIONS, HEAD, GET"},
 }
 
 func TestOptions(t *testing.T) {
@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// IndexPath is the path at which the index of mapped resources is served.
+const IndexPath = "/_rest/"
+
+// A Description describes a handler mapped with Map or Handle, as it is listed
+// in the index.  The Kind, ReadOnly flag and Methods are only known for
+// Resources.
+type Description struct {
+	Path     string
+	Type     string
+	Kind     string
+	ReadOnly bool
+	Methods  []string
+}
+
+var (
+	registry     = map[string]Handler{}
+	registryLock sync.RWMutex
+)
+
+// register records that the handler has been mapped at path.
+func register(path string, handler Handler) {
+	if _, ok := handler.(index); ok {
+		return
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[path] = handler
+}
+
+// Describe returns descriptions of the handlers mapped with Map or Handle,
+// sorted by path.
+func Describe() []Description {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	paths := make([]string, 0, len(registry))
+	for path := range registry {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	list := make([]Description, len(paths))
+	for i, path := range paths {
+		res, ok := registry[path].(*Resource)
+		if !ok {
+			list[i] = Description{
+				Path: path,
+				Type: fmt.Sprintf("%T", registry[path]),
+			}
+			continue
+		}
+		list[i] = res.describe()
+	}
+	return list
+}
+
+// describe returns the description of the resource.
+func (res *Resource) describe() Description {
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+
+	d := Description{
+		Path:     res.path,
+		Kind:     res.kind.String(),
+		ReadOnly: res.ro,
+	}
+	if res.value.IsValid() {
+		d.Type = res.value.Type().String()
+	}
+	if ent, err := res.resolve("", false); err == nil {
+		d.Methods = ent.methods()
+	}
+	return d
+}
+
+// index serves the descriptions of the mapped resources at IndexPath as a
+// read-only resource, the OpenAPI document at OpenAPIPath and batches of
+// requests at BatchPath.
+type index struct{}
+
+func (index) ServeREST(w http.ResponseWriter, r *http.Request) os.Error {
+	if r.URL.Path == OpenAPIPath {
+		return serveOpenAPI(w, r)
+	}
+	if r.URL.Path == BatchPath {
+		return serveBatch(w, r)
+	}
+
+	list := Describe()
+	res := &Resource{
+		ro:    true,
+		path:  IndexPath,
+		kind:  reflect.Slice,
+		value: reflect.ValueOf(list),
+	}
+	return res.ServeREST(w, r)
+}
+
+func init() {
+	Handle(IndexPath, index{})
+}
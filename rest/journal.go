@@ -0,0 +1,275 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"http"
+	"io"
+	"io/ioutil"
+	"json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// JournalCompact is the number of entries after which a journal is compacted
+// into a snapshot.
+var JournalCompact = 1000
+
+// A journalEntry records a request which modified a resource.
+type journalEntry struct {
+	Version int64
+	Method  string
+	Path    string
+	Query   string `json:",omitempty"`
+	Type    string `json:",omitempty"`
+	Body    string `json:",omitempty"`
+}
+
+// A journalSnapshot holds the value of a resource and the version of the last
+// entry it includes.
+type journalSnapshot struct {
+	Version int64
+	Value   *json.RawMessage
+}
+
+// A journal is the file to which the requests modifying a resource are
+// appended.
+type journal struct {
+	file    *os.File
+	name    string
+	entries int
+}
+
+// Journal keeps the value of the resource in a journal of the requests which
+// modify it, in the named file, and a snapshot of its value, in the named file
+// with ".snapshot" appended.  When the journal is opened, the value is
+// restored from the snapshot and the requests in the journal made since are
+// served again (in the same way as they were served originally).  If the end
+// of the journal is incomplete or corrupt, as it may be if the process stopped
+// while writing it, it is truncated.
+//
+// Afterwards, each request which modifies the resource is appended to the
+// journal before the reply is sent, and every JournalCompact requests, the
+// snapshot is replaced and the journal emptied.  If the request cannot be
+// appended, its change is undone and the request fails.  Calls to methods and functions are not
+// journaled, since they would be made again when the journal is replayed;
+// the snapshot is replaced after each of them instead.
+func (res *Resource) Journal(file string) os.Error {
+	res.lock.Lock()
+	defer res.lock.Unlock()
+
+	j := &journal{name: file}
+	if data, err := ioutil.ReadFile(j.snapshotName()); err == nil {
+		var snap journalSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return &FailedDecode{err, "application/json", res.value.Interface()}
+		}
+		if snap.Value == nil {
+			return &FailedDecode{os.NewError("no value"), "application/json", res.value.Interface()}
+		}
+		if err := res.restore(bytes.NewBuffer(*snap.Value)); err != nil {
+			return err
+		}
+		res.version = snap.Version
+	} else if _, serr := os.Stat(j.snapshotName()); serr == nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	j.file = f
+	if err := res.replay(j); err != nil {
+		f.Close()
+		return err
+	}
+
+	res.journal = j
+	return nil
+}
+
+// snapshotName returns the name of the file holding the journal's snapshot.
+func (j *journal) snapshotName() string {
+	return j.name + ".snapshot"
+}
+
+// replay serves the requests in the journal which are newer than its
+// snapshot, and truncates the journal after the last complete entry.
+func (res *Resource) replay(j *journal) os.Error {
+	in := bufio.NewReader(j.file)
+	var offset int64
+	for {
+		line, err := in.ReadString('\n')
+		if err == os.EOF && line == "" {
+			return nil
+		} else if err != nil && err != os.EOF {
+			return err
+		}
+
+		entry, perr := parseEntry(line)
+		if perr != nil {
+			log.Printf("rest: truncating journal %s at offset %d: %s", j.name, offset, perr)
+			return j.file.Truncate(offset)
+		}
+		offset += int64(len(line))
+		j.entries++
+
+		if entry.Version <= res.version {
+			continue
+		}
+		if err := res.apply(entry); err != nil {
+			return fmt.Errorf("rest: replaying version %d of %s: %s", entry.Version, res.path, err)
+		}
+		res.version = entry.Version
+	}
+
+	panic("unreachable")
+}
+
+// parseEntry parses a line of a journal, which holds the checksum of the
+// JSON encoding of an entry and the encoding.
+func parseEntry(line string) (*journalEntry, os.Error) {
+	if !strings.HasSuffix(line, "\n") {
+		return nil, os.NewError("incomplete entry")
+	}
+	line = line[:len(line)-1]
+
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return nil, os.NewError("missing checksum")
+	}
+	sum, err := strconv.Btoui64(line[:i], 16)
+	if err != nil {
+		return nil, os.NewError("bad checksum")
+	}
+	js := []byte(line[i+1:])
+	if uint32(sum) != crc32.ChecksumIEEE(js) {
+		return nil, os.NewError("checksum mismatch")
+	}
+
+	entry := new(journalEntry)
+	if err := json.Unmarshal(js, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// apply serves the request recorded in a journal entry.
+func (res *Resource) apply(entry *journalEntry) os.Error {
+	url := entry.Path
+	if entry.Query != "" {
+		url += "?" + entry.Query
+	}
+	r, err := http.NewRequest(entry.Method, url, strings.NewReader(entry.Body))
+	if err != nil {
+		return err
+	}
+	if entry.Type != "" {
+		r.Header.Set("Content-Type", entry.Type)
+	}
+	return res.ServeREST(discard{http.Header{}}, r)
+}
+
+// record appends the request, whose body has already been read, to the
+// journal, and compacts the journal if it has grown long enough.  If the
+// request cannot be appended, the journal is left as it was; once it has
+// been, the request is recorded, so a failure to compact is only logged.  The
+// resource must be locked.
+func (res *Resource) record(r *http.Request, body []byte) os.Error {
+	j := res.journal
+	entry := &journalEntry{
+		Version: res.version,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Type:    r.Header.Get("Content-Type"),
+		Body:    string(body),
+	}
+	js, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	offset, err := j.file.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(js), js)
+	_, err = io.WriteString(j.file, line)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// Part of the entry may have been written, and the entries after it
+		// would be lost when the journal is replayed
+		j.file.Truncate(offset)
+		return err
+	}
+	j.entries++
+
+	if j.entries >= JournalCompact {
+		if err := res.compact(); err != nil {
+			log.Printf("rest: compacting journal %s: %s", j.name, err)
+		}
+	}
+	return nil
+}
+
+// compact replaces the snapshot of the journal with the current value and
+// empties the journal.  The resource must be locked.
+func (res *Resource) compact() os.Error {
+	f, err := res.stageCompact()
+	if err != nil || f == nil {
+		return err
+	}
+	if err := f.commit(); err != nil {
+		return err
+	}
+	res.journal.truncate()
+	return nil
+}
+
+// stageCompact writes the snapshot which replaces the journal of the
+// resource, if it has one, to a temporary file; the resource must be locked.
+// The journal must be truncated once the snapshot is committed.
+func (res *Resource) stageCompact() (*stagedFile, os.Error) {
+	j := res.journal
+	if j == nil {
+		return nil, nil
+	}
+	value := bytes.NewBuffer(nil)
+	if err := res.snapshot(value); err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(value.Bytes())
+	js, err := json.Marshal(&journalSnapshot{res.version, &raw})
+	if err != nil {
+		return nil, err
+	}
+	return stageFile(j.snapshotName(), js)
+}
+
+// truncate empties the journal once a snapshot including its entries has
+// replaced the old one.  If this fails, the change has still been committed:
+// the entries are skipped when replayed, since the snapshot includes them.
+func (j *journal) truncate() {
+	if err := j.file.Truncate(0); err != nil {
+		log.Printf("rest: truncating journal %s: %s", j.name, err)
+		return
+	}
+	j.entries = 0
+}
+
+// discard is a ResponseWriter which discards the reply.
+type discard struct {
+	header http.Header
+}
+
+func (d discard) Header() http.Header            { return d.header }
+func (d discard) Write(p []byte) (int, os.Error) { return len(p), nil }
+func (d discard) WriteHeader(status int)         {}
@@ -0,0 +1,152 @@
+package rest
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// A TextUnmarshaler can parse itself from text.  Map keys whose types
+// implement TextUnmarshaler (or whose pointer types do) can be given in paths;
+// the key's String method, if it has one, should return the same text.
+type TextUnmarshaler interface {
+	UnmarshalText(text []byte) os.Error
+}
+
+// parseKey parses a map key of type kt from the given path segment.  Keys of
+// any string, integer, floating-point or boolean kind can be parsed, as can
+// keys which implement TextUnmarshaler.  If the segment cannot be parsed, the
+// returned Value is not valid.
+func parseKey(kt reflect.Type, seg string) reflect.Value {
+	ptr := reflect.New(kt)
+	if u, ok := ptr.Interface().(TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(seg)); err != nil {
+			return reflect.Value{}
+		}
+		return ptr.Elem()
+	}
+
+	key := ptr.Elem()
+	switch kt.Kind() {
+	case reflect.String:
+		key.SetString(seg)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.Atoi64(seg)
+		if err != nil || key.OverflowInt(n) {
+			return reflect.Value{}
+		}
+		key.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.Atoui64(seg)
+		if err != nil || key.OverflowUint(n) {
+			return reflect.Value{}
+		}
+		key.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.Atof64(seg)
+		if err != nil || key.OverflowFloat(f) {
+			return reflect.Value{}
+		}
+		key.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.Atob(seg)
+		if err != nil {
+			return reflect.Value{}
+		}
+		key.SetBool(b)
+	default:
+		return reflect.Value{}
+	}
+	return key
+}
+
+// formatKey returns the path segment which names the given map key.
+func formatKey(key reflect.Value) string {
+	if _, ok := reflect.New(key.Type()).Interface().(TextUnmarshaler); ok {
+		return fmt.Sprint(key.Interface())
+	}
+
+	switch key.Kind() {
+	case reflect.String:
+		return key.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.Itoa64(key.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.Uitoa64(key.Uint())
+	case reflect.Float32, reflect.Float64:
+		return strconv.Ftoa64(key.Float(), 'g', -1)
+	}
+	return fmt.Sprint(key.Interface())
+}
+
+// stringKeys returns a copy of the map m with its keys formatted as they are
+// in paths, so that it can be encoded as a JSON object.  Maps with string keys
+// are returned as they are.
+func stringKeys(m reflect.Value) interface{} {
+	if m.Type().Key().Kind() == reflect.String || m.IsNil() {
+		return m.Interface()
+	}
+
+	out := make(map[string]interface{}, m.Len())
+	for _, key := range m.MapKeys() {
+		out[formatKey(key)] = m.MapIndex(key).Interface()
+	}
+	return out
+}
+
+// keyField returns the index of the field of the struct type t (or of the
+// struct to which t points) which is tagged `rest:"key"`, or -1 if there is
+// none.  Elements of a slice of such structs can be named in paths by the
+// value of their key field as well as by their index.
+func keyField(t reflect.Type) int {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return -1
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("rest") == "key" {
+			return i
+		}
+	}
+	return -1
+}
+
+// findKey returns the index of the element of the slice or array list whose
+// key field (see keyField) is named by seg, or -1 if there is none.
+func findKey(list reflect.Value, field int, seg string) int {
+	for i := 0; i < list.Len(); i++ {
+		elem := indirect(list.Index(i))
+		if elem.Kind() == reflect.Struct && formatKey(elem.Field(field)) == seg {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkKey makes sure that the key field of val, which will be stored in the
+// entity, matches the key by which the entity was named.  If the key field of
+// val is empty, it is set from the name.  (Key fields need not be of a type
+// which can be compared with ==.)
+func (ent *entity) checkKey(val reflect.Value) os.Error {
+	if ent.name == "" {
+		return nil
+	}
+	field := keyField(val.Type())
+	if val = indirect(val); field < 0 || val.Kind() != reflect.Struct {
+		return nil
+	}
+
+	key := val.Field(field)
+	if reflect.DeepEqual(key.Interface(), reflect.Zero(key.Type()).Interface()) && key.CanSet() {
+		if k := parseKey(key.Type(), ent.name); k.IsValid() {
+			key.Set(k)
+		}
+	}
+	if got := formatKey(key); got != ent.name {
+		return &Invalid{ent.path, os.NewError("key " + strconv.Quote(got) + " does not match path")}
+	}
+	return nil
+}
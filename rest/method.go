@@ -0,0 +1,201 @@
+package rest
+
+import (
+	"http"
+	"json"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+var errorType = reflect.TypeOf((*os.Error)(nil)).Elem()
+
+// method returns the exported method of value whose name matches name without
+// regard to case, bound to value.  Methods with pointer receivers are found
+// if value is addressable.  Methods promoted from embedded fields (such as the
+// Lock method of an embedded sync.Mutex) are not found.  If there is no such
+// method, the returned Value is not valid.
+func method(value reflect.Value, name string) reflect.Value {
+	switch value.Kind() {
+	case reflect.Invalid, reflect.Interface:
+		return reflect.Value{}
+	case reflect.Ptr:
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+	}
+	if value.Kind() != reflect.Ptr && value.CanAddr() {
+		value = value.Addr()
+	}
+
+	lower := strings.ToLower(name)
+	t := value.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if m.PkgPath == "" && strings.ToLower(m.Name) == lower && !promoted(t, m.Name) {
+			return value.Method(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// promoted returns true if the method named name of the type t (or of the
+// type to which it points) is promoted from an embedded field, rather than
+// declared for the type itself (as it may be to shadow the embedded one).
+func promoted(t reflect.Type, name string) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !embedsMethod(t, name) {
+		return false
+	}
+	for _, mt := range []reflect.Type{t, reflect.PtrTo(t)} {
+		for i := 0; i < mt.NumMethod(); i++ {
+			if m := mt.Method(i); m.Name == name && !autogenerated(m.Func) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// embedsMethod returns true if an embedded field of the struct type t has a
+// method named name.
+func embedsMethod(t reflect.Type, name string) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		types := []reflect.Type{f.Type}
+		if k := f.Type.Kind(); k != reflect.Ptr && k != reflect.Interface {
+			types = append(types, reflect.PtrTo(f.Type))
+		}
+		for _, ft := range types {
+			for j := 0; j < ft.NumMethod(); j++ {
+				if ft.Method(j).Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// autogenerated returns true if the function is a wrapper generated by the
+// compiler, as promoted methods (and the methods of *T declared for T) are,
+// rather than one declared in the source.
+func autogenerated(fn reflect.Value) bool {
+	pc := fn.Pointer()
+	f := runtime.FuncForPC(pc)
+	if f == nil {
+		return true
+	}
+	file, _ := f.FileLine(pc)
+	return file == "<autogenerated>"
+}
+
+// getter returns true if a function of type t can be called to retrieve a
+// value: it takes no arguments and has a result other than an os.Error.
+// Other functions may only be called with POST, since they are presumably
+// called for their effects.
+func getter(t reflect.Type) bool {
+	switch n := t.NumOut(); {
+	case t.NumIn() > 0, n == 0:
+		return false
+	case n == 1:
+		return t.Out(0) != errorType
+	}
+	return true
+}
+
+// call invokes the function held by the entity.  The arguments are decoded
+// from the body of a POST: a single argument is decoded directly, and more than
+// one are decoded from a JSON array.  The results are encoded in the same way.
+// If the last result is an os.Error, it is not encoded; if it is not nil, it is
+// returned instead.
+func (ent *entity) call(w http.ResponseWriter, r *http.Request) os.Error {
+	fn := ent.value
+	ft := fn.Type()
+
+	mt := Negotiate(r.Header["Accept"])
+	if mt == nil {
+		return &NotAcceptable{ent.path, r.Header["Accept"]}
+	}
+	ctype := mt.Type + "/" + mt.SubType
+	w.Header().Set("Content-Type", ctype)
+
+	if r.Method == "HEAD" {
+		return nil
+	}
+
+	args := make([]reflect.Value, ft.NumIn())
+	ptrs := make([]reflect.Value, len(args))
+	for i := range args {
+		ptrs[i] = reflect.New(ft.In(i))
+		args[i] = ptrs[i].Elem()
+	}
+
+	if r.Method == "POST" && len(args) > 0 {
+		const argType = "application/json"
+		dec := json.NewDecoder(r.Body)
+		if len(args) == 1 {
+			if err := dec.Decode(ptrs[0].Interface()); err != nil {
+				return &FailedDecode{err, argType, fn.Interface()}
+			}
+		} else {
+			var raw []json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return &FailedDecode{err, argType, fn.Interface()}
+			}
+			if len(raw) != len(args) {
+				err := os.NewError("wrong number of arguments")
+				return &FailedDecode{err, argType, fn.Interface()}
+			}
+			for i := range raw {
+				if err := json.Unmarshal(raw[i], ptrs[i].Interface()); err != nil {
+					return &FailedDecode{err, argType, fn.Interface()}
+				}
+			}
+		}
+	}
+
+	out := fn.Call(args)
+	if n := len(out); n > 0 && ft.Out(n-1) == errorType {
+		if err := out[n-1].Interface(); err != nil {
+			return err.(os.Error)
+		}
+		out = out[:n-1]
+	}
+
+	var result reflect.Value
+	switch len(out) {
+	case 0:
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case 1:
+		result = out[0]
+	default:
+		results := make([]interface{}, len(out))
+		for i := range out {
+			results[i] = out[i].Interface()
+		}
+		result = reflect.ValueOf(results)
+	}
+
+	js, err := LookupCodec(ctype).Encode(result, ent.path)
+	if err != nil {
+		return &FailedEncode{err, ctype, result.Interface()}
+	}
+
+	if _, err := w.Write(js); err != nil {
+		return err
+	}
+
+	return nil
+}
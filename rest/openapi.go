@@ -0,0 +1,436 @@
+package rest
+
+import (
+	"http"
+	"json"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenAPIPath is the path at which the OpenAPI document describing the mapped
+// resources is served.
+const OpenAPIPath = IndexPath + "openapi.json"
+
+// OpenAPIInfo is the info object of the OpenAPI document, which must have a
+// title and a version.
+var OpenAPIInfo = map[string]string{
+	"title":   "REST resources",
+	"version": "1",
+}
+
+// errorDocs describes the errors in problemErrors: the methods which may
+// produce them (all methods, if empty), whether they only occur for channels,
+// and what they mean.  The status they are sent with is their ErrorCode.
+var errorDocs = map[string]struct {
+	Methods     string
+	Chan        bool
+	Description string
+}{
+	"BadSub":             {"", false, "no such sub-resource"},
+	"BadMethod":          {"", false, "method not allowed"},
+	"UnhandledType":      {"", false, "value of an unhandled type"},
+	"FailedEncode":       {"GET", false, "value could not be encoded"},
+	"NotAcceptable":      {"GET", false, "no acceptable media type"},
+	"BadQuery":           {"GET", false, "invalid query parameters"},
+	"BadRange":           {"GET", false, "requested range has no items"},
+	"NoVersion":          {"GET POST", false, "no such version in the history"},
+	"FailedDecode":       {"PUT PATCH POST", false, "body could not be decoded"},
+	"SchemaError":        {"PUT PATCH", false, "body does not match the schema"},
+	"UnknownType":        {"PUT", false, "body names an unknown or unsuitable type"},
+	"Invalid":            {"PUT PATCH POST", false, "value failed validation"},
+	"Unsettable":         {"PUT PATCH POST DELETE", false, "value cannot be modified"},
+	"PreconditionFailed": {"GET PUT PATCH POST DELETE", false, "entity tag does not match"},
+	"Duplicate":          {"POST", false, "element with the same key exists"},
+	"Closed":             {"GET POST", true, "channel has been closed"},
+	"Timeout":            {"POST", true, "channel did not accept the value in time"},
+}
+
+// An errorResponse is an error sent to clients, with the status it is sent
+// with and its description from errorDocs.
+type errorResponse struct {
+	Error       string
+	Status      int
+	Methods     string
+	Chan        bool
+	Description string
+}
+
+// errorResponses returns the errors in problemErrors, sorted by name, with
+// the statuses their ErrorCode methods return.
+func errorResponses() []errorResponse {
+	names := make([]string, 0, len(problemErrors))
+	for name := range problemErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]errorResponse, 0, len(names))
+	for _, name := range names {
+		e := errorResponse{Error: name, Status: http.StatusInternalServerError}
+		if ec, ok := reflect.New(problemErrors[name]).Interface().(ErrorCoder); ok {
+			e.Status = ec.ErrorCode()
+		}
+		doc := errorDocs[name]
+		e.Methods, e.Chan, e.Description = doc.Methods, doc.Chan, doc.Description
+		list = append(list, e)
+	}
+	return list
+}
+
+// An apiParam is a parameter in the path of an operation.
+type apiParam struct {
+	name   string
+	schema schema
+}
+
+// An apiDoc accumulates the paths and schemas of an OpenAPI document.
+type apiDoc struct {
+	schemas *schemaBuilder
+	paths   map[string]map[string]interface{}
+	errors  []errorResponse
+}
+
+// OpenAPI returns an OpenAPI 3.1 document describing the paths below every
+// Resource mapped with Map or Handle, their operations and the schemas of
+// their values.  Struct fields, map elements (as a {key} parameter), slice
+// and array elements (as an {index} parameter), computed properties and
+// methods are described as paths below their parents.
+func OpenAPI() ([]byte, os.Error) {
+	d := &apiDoc{
+		schemas: newSchemaBuilder("#/components/schemas/"),
+		errors:  errorResponses(),
+		paths:   map[string]map[string]interface{}{},
+	}
+
+	registryLock.RLock()
+	for _, handler := range registry {
+		if res, ok := handler.(*Resource); ok {
+			d.resource(res)
+		}
+	}
+	registryLock.RUnlock()
+
+	return json.Marshal(map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    OpenAPIInfo,
+		"paths":   d.paths,
+		"components": map[string]interface{}{
+			"schemas": d.schemas.defs,
+		},
+	})
+}
+
+// serveOpenAPI serves the OpenAPI document.
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) os.Error {
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		return nil
+	case "HEAD", "GET":
+	default:
+		return &BadMethod{r.URL.Path, r.Method, nil, []string{"OPTIONS", "HEAD", "GET"}}
+	}
+
+	js, err := OpenAPI()
+	if err != nil {
+		return &FailedEncode{err, "application/json", nil}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "HEAD" {
+		return nil
+	}
+	_, err = w.Write(js)
+	return err
+}
+
+// resource adds the paths below the resource to the document.
+func (d *apiDoc) resource(res *Resource) {
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+
+	t := res.value.Type()
+	root := &entity{
+		ro:    res.ro,
+		value: reflect.New(t).Elem(),
+		props: res.props,
+	}
+	stack := map[reflect.Type]bool{}
+	d.path(root, res.path, d.schemas.withProps(d.schemas.schema(t), res.props), nil)
+
+	for _, prop := range res.props {
+		sub := &entity{
+			ro:    res.ro,
+			value: reflect.New(prop.getter.Type().Out(0)).Elem(),
+			prop:  prop,
+		}
+		d.walk(sub, subPath(res.path, strings.ToLower(prop.name)), nil, stack)
+	}
+	d.children(root, res.path, nil, stack)
+}
+
+// walk adds the path of the entity and the paths below it to the document.
+// The entity holds a zero value of the type which would be found there.
+// Types being walked are marked in stack, so that recursive types are only
+// described to the depth at which they recur.
+func (d *apiDoc) walk(ent *entity, tmpl string, params []apiParam, stack map[reflect.Type]bool) {
+	if _, ok := d.paths[tmpl]; ok {
+		return
+	}
+	d.path(ent, tmpl, nil, params)
+	d.children(ent, tmpl, params, stack)
+}
+
+// children adds the paths below the entity to the document.
+func (d *apiDoc) children(ent *entity, tmpl string, params []apiParam, stack map[reflect.Type]bool) {
+	t := ent.value.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if stack[t] || opaque(t) {
+		return
+	}
+	stack[t] = true
+	defer func() { stack[t] = false, false }()
+
+	value := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				sub := &entity{ro: ent.ro, value: value.Field(i)}
+				d.walk(sub, subPath(tmpl, strings.ToLower(f.Name)), params, stack)
+			}
+		}
+	case reflect.Map:
+		p := param(params, "key", d.schemas.schema(t.Key()))
+		sub := &entity{ro: ent.ro, value: reflect.New(t.Elem()).Elem(), parent: value}
+		d.walk(sub, subPath(tmpl, "{"+p.name+"}"), append(params, p), stack)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		ps := schema{"type": "integer"}
+		if keyField(t.Elem()) >= 0 {
+			ps = schema{"type": "string"}
+		}
+		p := param(params, "index", ps)
+		sub := &entity{ro: ent.ro, value: reflect.New(t.Elem()).Elem(), parent: value}
+		d.walk(sub, subPath(tmpl, "{"+p.name+"}"), append(params, p), stack)
+	case reflect.Interface:
+		return
+	}
+
+	ptr := value.Addr()
+	for i := 0; i < ptr.NumMethod(); i++ {
+		if m := ptr.Type().Method(i); m.PkgPath == "" && !promoted(ptr.Type(), m.Name) {
+			sub := &entity{ro: ent.ro, value: ptr.Method(i)}
+			d.walk(sub, subPath(tmpl, strings.ToLower(m.Name)), params, stack)
+		}
+	}
+}
+
+// param returns a path parameter with the given name, numbered if a parameter
+// of the same name is already in params.
+func param(params []apiParam, name string, s schema) apiParam {
+	n := 1
+	for _, p := range params {
+		if strings.HasPrefix(p.name, name) {
+			n++
+		}
+	}
+	if n > 1 {
+		name += strconv.Itoa(n)
+	}
+	return apiParam{name, s}
+}
+
+// path adds the operations allowed on the entity to the document.  If s is
+// nil, the schema of the entity is derived from its type.
+func (d *apiDoc) path(ent *entity, tmpl string, s schema, params []apiParam) {
+	t := ent.value.Type()
+	if s == nil {
+		s = d.schemas.schema(t)
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	item := map[string]interface{}{}
+	for _, method := range ent.methods() {
+		op := d.operation(t, s, method)
+		if op == nil {
+			continue
+		}
+		responses := op["responses"].(map[string]interface{})
+		for _, e := range d.errors {
+			if e.Methods != "" && strings.Index(e.Methods, method) < 0 {
+				continue
+			}
+			if e.Chan && t.Kind() != reflect.Chan {
+				continue
+			}
+			code := strconv.Itoa(e.Status)
+			desc := e.Error + ": " + e.Description
+			if r, ok := responses[code].(map[string]interface{}); ok {
+				desc = r["description"].(string) + "; " + desc
+			}
+			responses[code] = map[string]interface{}{
+				"description": desc,
+				"content":     content("text/plain", schema{"type": "string"}),
+			}
+		}
+		item[strings.ToLower(method)] = op
+	}
+	if len(item) == 0 {
+		return
+	}
+
+	if len(params) > 0 {
+		list := make([]interface{}, len(params))
+		for i, p := range params {
+			list[i] = map[string]interface{}{
+				"name":     p.name,
+				"in":       "path",
+				"required": true,
+				"schema":   p.schema,
+			}
+		}
+		item["parameters"] = list
+	}
+	d.paths[tmpl] = item
+}
+
+// operation returns the operation object for the method on a value of type t
+// (which is not a pointer) whose schema is s, or nil if the method is not
+// described.
+func (d *apiDoc) operation(t reflect.Type, s schema, method string) map[string]interface{} {
+	responses := map[string]interface{}{}
+	op := map[string]interface{}{"responses": responses}
+
+	if t.Kind() == reflect.Func {
+		args, results := d.signature(t)
+		switch method {
+		case "GET":
+		case "POST":
+			if args != nil {
+				op["requestBody"] = body(args)
+			}
+		default:
+			return nil
+		}
+		if results == nil {
+			responses["204"] = response("called")
+		} else {
+			responses["200"] = response("called", results)
+		}
+		return op
+	}
+
+	if t.Kind() == reflect.Chan {
+		elem := d.schemas.schema(t.Elem())
+		switch method {
+		case "GET":
+			responses["200"] = response("received a value", elem)
+			responses["204"] = response("no value was received")
+		case "POST":
+			op["requestBody"] = body(elem)
+			responses["204"] = response("sent the value")
+		default:
+			return nil
+		}
+		return op
+	}
+
+	switch method {
+	case "GET":
+		responses["200"] = response("the value", s)
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			responses["206"] = response("the requested range of elements", s)
+		}
+	case "PUT":
+		op["requestBody"] = body(s)
+		responses["201"] = response("created the value")
+		responses["204"] = response("replaced the value")
+	case "PATCH":
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content(MergePatchType, schema{}),
+		}
+		responses["204"] = response("patched the value")
+	case "POST":
+		switch t.Kind() {
+		case reflect.Slice:
+			op["requestBody"] = body(d.schemas.schema(t.Elem()))
+			responses["201"] = response("appended the element")
+		case reflect.Map:
+			op["requestBody"] = body(s)
+			responses["201"] = response("added the elements")
+		default:
+			return nil
+		}
+	case "DELETE":
+		responses["204"] = response("removed the element")
+	default:
+		return nil
+	}
+	return op
+}
+
+// signature returns the schemas of the body of a POST to the function type t
+// and of its results, either of which is nil if there are none.  A trailing
+// os.Error result is not included.
+func (d *apiDoc) signature(t reflect.Type) (args, results schema) {
+	in := make([]interface{}, t.NumIn())
+	for i := range in {
+		in[i] = d.schemas.schema(t.In(i))
+	}
+	n := t.NumOut()
+	if n > 0 && t.Out(n-1) == errorType {
+		n--
+	}
+	out := make([]interface{}, n)
+	for i := range out {
+		out[i] = d.schemas.schema(t.Out(i))
+	}
+	return tuple(in), tuple(out)
+}
+
+// tuple returns the schema of a list of values which are encoded as a single
+// value if there is only one and as an array if there is more than one.
+func tuple(list []interface{}) schema {
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return list[0].(schema)
+	}
+	return schema{"type": "array", "prefixItems": list, "items": false}
+}
+
+// content returns a content map with a single media type.
+func content(mediaType string, s schema) map[string]interface{} {
+	return map[string]interface{}{
+		mediaType: map[string]interface{}{"schema": s},
+	}
+}
+
+// body returns a request body object with the given JSON schema.
+func body(s schema) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content":  content("application/json", s),
+	}
+}
+
+// response returns a response object with the given description and, if one
+// is given, a JSON body with the given schema.
+func response(desc string, s ...schema) map[string]interface{} {
+	r := map[string]interface{}{"description": desc}
+	if len(s) > 0 {
+		r["content"] = content("application/json", s[0])
+	}
+	return r
+}
@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"http"
+	"io/ioutil"
+	"json"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// MergePatchType is the media type of JSON merge patches (RFC 7386), which
+// are the bodies of PATCH requests.
+const MergePatchType = "application/merge-patch+json"
+
+// mergePatch applies a merge patch to target, both of which are decoded JSON
+// values, and returns the result.  Members of the patch which are null are
+// removed from the target; other members are merged into it recursively.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			t[k] = nil, false
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// toJSON returns the decoded JSON encoding of val.
+func toJSON(val reflect.Value) (interface{}, os.Error) {
+	js, err := jsonCodec{}.Encode(val, "")
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(js, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// merge returns a new value of the same type as val, which is the result of
+// applying the merge patch to it.  The patched fields of a structure are
+// replaced with newly decoded values; its other fields are copied.
+func merge(val reflect.Value, patch interface{}) (reflect.Value, os.Error) {
+	obj, ok := patch.(map[string]interface{})
+	if val.Kind() != reflect.Struct || !ok {
+		cur, err := toJSON(val)
+		if err != nil {
+			return reflect.Value{}, &FailedEncode{err, "application/json", val.Interface()}
+		}
+		js, err := json.Marshal(mergePatch(cur, patch))
+		if err != nil {
+			return reflect.Value{}, &FailedEncode{err, "application/json", val.Interface()}
+		}
+		return decodeJSON(js, reflect.Zero(val.Type()))
+	}
+
+	out := reflect.New(val.Type()).Elem()
+	out.Set(val)
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, _ := jsonKey(f)
+		if f.PkgPath != "" || key == "" {
+			continue
+		}
+		p, ok := obj[key]
+		if !ok {
+			// The json package also matches member names without regard to case
+			for k, v := range obj {
+				if strings.ToLower(k) == strings.ToLower(key) {
+					p, ok = v, true
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		fv, err := merge(val.Field(i), p)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.Field(i).Set(fv)
+	}
+	return out, nil
+}
+
+// patch applies the merge patch in the request body to the entity.  The
+// patched value is checked against the schema of the entity before it is
+// decoded, and is stored in the same way as the body of a PUT.
+func (ent *entity) patch(w http.ResponseWriter, r *http.Request) os.Error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &FailedDecode{err, MergePatchType, ent.value.Interface()}
+	}
+	var p interface{}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return &FailedDecode{err, MergePatchType, ent.value.Interface()}
+	}
+
+	cur, err := toJSON(ent.value)
+	if err != nil {
+		return &FailedEncode{err, "application/json", ent.value.Interface()}
+	}
+	if err := ent.checkValue(mergePatch(cur, p)); err != nil {
+		return err
+	}
+
+	val, err := merge(ent.value, p)
+	if err != nil {
+		return err
+	}
+	if err := ent.set(val); err != nil {
+		return err
+	}
+
+	if tag := ent.etag(val); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
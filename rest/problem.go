@@ -0,0 +1,146 @@
+package rest
+
+import (
+	"http"
+	"json"
+	"os"
+	"reflect"
+)
+
+// ProblemType is the media type of the problem details (RFC 7807) which are
+// sent instead of plain text for errors if the client accepts them.
+const ProblemType = "application/problem+json"
+
+// errorTypes are the media types in which errors can be sent; plain text is
+// preferred unless problem details are asked for.
+var errorTypes = ParseMediaTypes([]string{"text/plain, " + ProblemType})
+
+// A Problem holds the problem details of an error.  The Error member names
+// the type of the error (such as "BadSub") and the Fields member holds the
+// fields of the error which can be encoded, so that a client can recover
+// the error with Err.
+type Problem struct {
+	Type     string                 `json:"type,omitempty"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+func (p *Problem) String() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return "rest: " + p.Title
+}
+func (p *Problem) ErrorCode() int {
+	return p.Status
+}
+
+// problemErrors are the errors which can be recovered from problem details,
+// by name.
+var problemErrors = map[string]reflect.Type{}
+
+func init() {
+	for _, err := range []os.Error{
+		&UnhandledType{}, &BadMethod{}, &BadSub{}, &FailedEncode{}, &FailedDecode{},
+		&Unsettable{}, &UnknownType{}, &Invalid{}, &Closed{}, &Timeout{}, &Duplicate{},
+		&BadQuery{}, &BadRange{}, &NotAcceptable{}, &SchemaError{}, &PreconditionFailed{},
+		&NoVersion{},
+	} {
+		t := reflect.TypeOf(err).Elem()
+		problemErrors[t.Name()] = t
+	}
+}
+
+// newProblem returns the problem details of an error sent with the given
+// status in reply to a request for path.  Fields holding errors are sent as
+// their messages; fields holding other interfaces are not sent.
+func newProblem(err os.Error, status int, path string) *Problem {
+	p := &Problem{
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.String(),
+		Instance: path,
+	}
+
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return p
+	}
+	v = v.Elem()
+	if t, ok := problemErrors[v.Type().Name()]; !ok || t != v.Type() {
+		return p
+	}
+
+	p.Error = v.Type().Name()
+	p.Fields = map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		f, fv := v.Type().Field(i), v.Field(i)
+		switch fv.Kind() {
+		case reflect.Interface:
+			if e, ok := fv.Interface().(os.Error); ok {
+				p.Fields[f.Name] = e.String()
+			}
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Ptr:
+		default:
+			p.Fields[f.Name] = fv.Interface()
+		}
+	}
+	return p
+}
+
+// Err returns the error described by the problem details: an error of the
+// type it names, with the fields which were sent, or the Problem itself if
+// the type is not known.  Fields which held errors hold errors with the same
+// messages.
+func (p *Problem) Err() os.Error {
+	t, ok := problemErrors[p.Error]
+	if !ok {
+		return p
+	}
+
+	v := reflect.New(t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		raw, ok := p.Fields[f.Name]
+		if !ok {
+			continue
+		}
+		if f.Type == errorType {
+			if msg, ok := raw.(string); ok {
+				v.Elem().Field(i).Set(reflect.ValueOf(os.NewError(msg)))
+			}
+			continue
+		}
+		if f.Type.Kind() == reflect.Interface {
+			continue
+		}
+		js, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+		json.Unmarshal(js, v.Elem().Field(i).Addr().Interface())
+	}
+	return v.Interface().(os.Error)
+}
+
+// sendProblem sends the problem details of the error, if the client accepts
+// them, and returns true if they were sent.
+func sendProblem(w http.ResponseWriter, r *http.Request, err os.Error, status int) bool {
+	mt := errorTypes.Choose(ParseMediaTypes(r.Header["Accept"]))
+	if mt == nil || mt.Type+"/"+mt.SubType != ProblemType {
+		return false
+	}
+
+	js, jerr := json.Marshal(newProblem(err, status, r.URL.Path))
+	if jerr != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", ProblemType)
+	w.WriteHeader(status)
+	w.Write(js)
+	return true
+}
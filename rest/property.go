@@ -0,0 +1,156 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"json"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// A property is a computed value which is served alongside the fields of the
+// value at the root of a Resource.
+type property struct {
+	name   string
+	getter reflect.Value
+	setter reflect.Value
+}
+
+// Property adds a computed property to the root of the resource.  The property
+// is included in the encoding of the root object and can be accessed below it
+// by name (without regard to case) in the same way as a field.
+//
+// The getter must be a function taking no arguments which returns the value of
+// the property and, optionally, an os.Error.  The setter may be nil, in which
+// case the property is read-only; otherwise, it must be a function which takes
+// a single argument of the same type as the getter returns and, optionally,
+// returns an os.Error.  Values written to a property are validated and the
+// setter is called while the resource is locked, just as for a field.
+//
+// The name must differ (without regard to case) from those of the other
+// properties and of the exported fields of the root object.
+func (res *Resource) Property(name string, getter, setter interface{}) os.Error {
+	prop := &property{
+		name:   name,
+		getter: reflect.ValueOf(getter),
+	}
+
+	gt := prop.getter.Type()
+	if gt.Kind() != reflect.Func || gt.NumIn() != 0 ||
+		gt.NumOut() < 1 || gt.NumOut() > 2 || gt.NumOut() == 2 && gt.Out(1) != errorType {
+		return fmt.Errorf("rest: property %s: invalid getter type %v", name, gt)
+	}
+
+	if setter != nil {
+		prop.setter = reflect.ValueOf(setter)
+		st := prop.setter.Type()
+		if st.Kind() != reflect.Func || st.NumIn() != 1 || st.In(0) != gt.Out(0) ||
+			st.NumOut() > 1 || st.NumOut() == 1 && st.Out(0) != errorType {
+			return fmt.Errorf("rest: property %s: invalid setter type %v", name, st)
+		}
+	}
+
+	res.lock.Lock()
+	defer res.lock.Unlock()
+	if lookup(res.props, name) != nil || hasField(res.value, name) {
+		return fmt.Errorf("rest: property %s: name already in use", name)
+	}
+	res.props = append(res.props, prop)
+	return nil
+}
+
+// hasField returns true if the structure v holds (or points to) has an
+// exported field named, or encoded as, name without regard to case.
+func hasField(v reflect.Value, name string) bool {
+	v = indirect(v)
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	lower := strings.ToLower(name)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if key, _ := jsonKey(f); strings.ToLower(f.Name) == lower || strings.ToLower(key) == lower {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the property whose name matches name without regard to case,
+// or nil if there is none.
+func lookup(props []*property, name string) *property {
+	lower := strings.ToLower(name)
+	for _, prop := range props {
+		if strings.ToLower(prop.name) == lower {
+			return prop
+		}
+	}
+	return nil
+}
+
+// get calls the getter and returns the value of the property.
+func (prop *property) get() (reflect.Value, os.Error) {
+	out := prop.getter.Call(nil)
+	if len(out) == 2 {
+		if err := out[1].Interface(); err != nil {
+			return reflect.Value{}, err.(os.Error)
+		}
+	}
+	return out[0], nil
+}
+
+// set calls the setter with the new value of the property.  If the property
+// is a pointer, val may be the (addressable) value to which it should point.
+func (prop *property) set(val reflect.Value) os.Error {
+	if want := prop.setter.Type().In(0); val.Type() != want && val.CanAddr() {
+		val = val.Addr()
+	}
+	out := prop.setter.Call([]reflect.Value{val})
+	if len(out) == 1 {
+		if err := out[0].Interface(); err != nil {
+			return err.(os.Error)
+		}
+	}
+	return nil
+}
+
+// addProperties adds the encoded values of the given properties to the end of
+// the JSON object in js.
+func addProperties(js []byte, props []*property) ([]byte, os.Error) {
+	js = bytes.TrimSpace(js)
+	if len(js) < 2 || js[len(js)-1] != '}' {
+		return js, nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(js[:len(js)-1])
+	empty := len(bytes.TrimSpace(js[1:len(js)-1])) == 0
+
+	for _, prop := range props {
+		val, err := prop.get()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := json.Marshal(prop.name)
+		enc, err := json.Marshal(val.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		if !empty {
+			buf.WriteByte(',')
+		}
+		empty = false
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(enc)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
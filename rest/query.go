@@ -0,0 +1,477 @@
+package rest
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// page returns the part of the slice or array val requested by the offset and
+// limit query parameters or the Range header (in items) of the request, along
+// with the status with which it should be sent.  The Content-Range and Link
+// headers describing the part are set on w.  If no part is requested, the
+// whole slice is returned with an HTTP OK status.  If index is not nil, it
+// holds the original index of each element of val; the indices of the
+// elements of the part are returned in the same way.
+func (ent *entity) page(w http.ResponseWriter, r *http.Request, val reflect.Value, index []int) (reflect.Value, []int, int, os.Error) {
+	total := val.Len()
+	w.Header().Set("Accept-Ranges", "items")
+
+	start, end, ok, err := requestedRange(r, total)
+	if err != nil {
+		return reflect.Value{}, nil, 0, &BadQuery{ent.path, err}
+	}
+	if !ok {
+		return val, index, http.StatusOK, nil
+	}
+	if start >= total && total > 0 || start > total {
+		w.Header().Set("Content-Range", fmt.Sprintf("items */%d", total))
+		return reflect.Value{}, nil, 0, &BadRange{ent.path, start, total}
+	}
+
+	var part reflect.Value
+	if val.Kind() == reflect.Slice || val.CanAddr() {
+		part = val.Slice(start, end)
+	} else {
+		items := make([]interface{}, end-start)
+		for i := range items {
+			items[i] = val.Index(start + i).Interface()
+		}
+		part = reflect.ValueOf(items)
+	}
+
+	partIndex := make([]int, end-start)
+	for i := range partIndex {
+		if index != nil {
+			partIndex[i] = index[start+i]
+		} else {
+			partIndex[i] = start + i
+		}
+	}
+
+	if end > start {
+		w.Header().Set("Content-Range", fmt.Sprintf("items %d-%d/%d", start, end-1, total))
+	} else {
+		w.Header().Set("Content-Range", fmt.Sprintf("items */%d", total))
+	}
+
+	limit := end - start
+	link := func(offset int, rel string) {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(limit))
+		w.Header().Add("Link", fmt.Sprintf("<%s?%s>; rel=%q", r.URL.Path, q.Encode(), rel))
+	}
+	if limit > 0 && end < total {
+		link(end, "next")
+	}
+	if limit > 0 && start > 0 {
+		prev := start - limit
+		if prev < 0 {
+			prev = 0
+		}
+		link(prev, "prev")
+	}
+
+	return part, partIndex, http.StatusPartialContent, nil
+}
+
+// requestedRange returns the range [start, end) of items requested from a
+// collection of the given size, and whether a range was requested at all.
+// The offset and limit query parameters take precedence over a Range header,
+// which is ignored unless it is in items.  The end of the range is clamped
+// to the size of the collection.
+func requestedRange(r *http.Request, total int) (start, end int, ok bool, err os.Error) {
+	end = total
+
+	q := r.URL.Query()
+	if offset, limit := q.Get("offset"), q.Get("limit"); offset != "" || limit != "" {
+		if offset != "" {
+			if start, err = strconv.Atoi(offset); err != nil || start < 0 {
+				return 0, 0, false, os.NewError("invalid offset " + strconv.Quote(offset))
+			}
+		}
+		if limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				return 0, 0, false, os.NewError("invalid limit " + strconv.Quote(limit))
+			}
+			end = start + n
+		}
+	} else if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "items=") {
+		spec := rng[len("items="):]
+		dash := strings.Index(spec, "-")
+		if dash < 0 || strings.Index(spec, ",") >= 0 {
+			return 0, 0, false, os.NewError("invalid range " + strconv.Quote(rng))
+		}
+		if start, err = strconv.Atoi(spec[:dash]); err != nil || start < 0 {
+			return 0, 0, false, os.NewError("invalid range " + strconv.Quote(rng))
+		}
+		if last := spec[dash+1:]; last != "" {
+			n, err := strconv.Atoi(last)
+			if err != nil || n < start {
+				return 0, 0, false, os.NewError("invalid range " + strconv.Quote(rng))
+			}
+			end = n + 1
+		}
+	} else {
+		return 0, 0, false, nil
+	}
+
+	if end > total {
+		end = total
+	}
+	if end < start {
+		end = start
+	}
+	return start, end, true, nil
+}
+
+// A condition is a single comparison in a filter expression, such as
+// "status==running".  The path names a field of each element (see field).
+type condition struct {
+	path []string
+	op   string
+	lit  string
+}
+
+// filterOps are the comparison operators understood in filter expressions.
+// Those which are a prefix of another must be listed after it.
+var filterOps = []string{"==", "!=", "<=", ">=", "~=", "^=", "<", ">"}
+
+// parseFilter parses a filter expression, which consists of alternative
+// conditions separated by ",", at least one of which must be met.  (Filters
+// which must all be met are given as separate filter parameters.)  A literal
+// may be quoted as a Go string, such as "a,b", to include "," or quotes.
+func parseFilter(expr string) ([]condition, os.Error) {
+	var or []condition
+	for _, term := range splitFilter(expr) {
+		c, ok := condition{}, false
+		for i := 0; i < len(term) && !ok; i++ {
+			for _, op := range filterOps {
+				if strings.HasPrefix(term[i:], op) {
+					c.path = strings.Split(strings.ToLower(term[:i]), ".")
+					c.op, c.lit = op, term[i+len(op):]
+					ok = true
+					break
+				}
+			}
+		}
+		if !ok || len(c.path[0]) == 0 {
+			return nil, os.NewError("invalid filter condition " + strconv.Quote(term))
+		}
+		if strings.HasPrefix(c.lit, `"`) {
+			lit, err := strconv.Unquote(c.lit)
+			if err != nil {
+				return nil, os.NewError("invalid filter literal " + c.lit)
+			}
+			c.lit = lit
+		}
+		or = append(or, c)
+	}
+	return or, nil
+}
+
+// splitFilter splits a filter expression at each "," which is not within a
+// quoted literal.
+func splitFilter(expr string) []string {
+	var terms []string
+	quoted, start := false, 0
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			terms = append(terms, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+// match returns true if the field of the element named by the condition meets
+// it.  Missing fields and nil values meet no conditions.
+func (c *condition) match(elem, key reflect.Value) bool {
+	v := field(elem, key, c.path)
+	if !v.IsValid() {
+		return false
+	}
+
+	switch c.op {
+	case "~=":
+		return strings.Index(formatKey(v), c.lit) >= 0
+	case "^=":
+		return strings.HasPrefix(formatKey(v), c.lit)
+	}
+
+	lit := parseKey(v.Type(), c.lit)
+	if !lit.IsValid() {
+		return false
+	}
+	cmp := compare(v, lit)
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// field returns the value named by the dotted path within an element of a
+// collection.  Each element of the path names a field (without regard to case)
+// of a structure or an element of a map with string keys.  For elements of a
+// map, a path beginning with "key" names the key.  A path beginning with
+// "value" names the element itself.  If the path names nothing, the returned
+// Value is not valid.
+func field(elem, key reflect.Value, path []string) reflect.Value {
+	v := elem
+	switch {
+	case path[0] == "key" && key.IsValid():
+		v, path = key, path[1:]
+	case path[0] == "value":
+		path = path[1:]
+	}
+
+	for _, name := range path {
+		switch v = indirect(v); v.Kind() {
+		case reflect.Struct:
+			v = v.FieldByNameFunc(func(field string) bool {
+				return strings.ToLower(field) == name
+			})
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}
+			}
+			k := reflect.New(v.Type().Key()).Elem()
+			k.SetString(name)
+			v = v.MapIndex(k)
+		default:
+			return reflect.Value{}
+		}
+	}
+
+	if v = indirect(v); v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		return reflect.Value{}
+	}
+	return v
+}
+
+// compare returns a negative number, zero or a positive number if a is less
+// than, equal to or greater than b, respectively.  Numbers, strings and
+// booleans (false before true) compare naturally; other values are compared
+// by their formatted text.  Values of different classes (as the elements of
+// an interface{} may be) are ordered by class: booleans, then signed,
+// unsigned and floating-point numbers, then everything else.  An invalid
+// value is less than any other.
+func compare(a, b reflect.Value) int {
+	switch {
+	case !a.IsValid() && !b.IsValid():
+		return 0
+	case !a.IsValid():
+		return -1
+	case !b.IsValid():
+		return +1
+	}
+
+	switch x, y := kindClass(a.Kind()), kindClass(b.Kind()); {
+	case x < y:
+		return -1
+	case x > y:
+		return +1
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch x, y := a.Int(), b.Int(); {
+		case x < y:
+			return -1
+		case x > y:
+			return +1
+		}
+		return 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch x, y := a.Uint(), b.Uint(); {
+		case x < y:
+			return -1
+		case x > y:
+			return +1
+		}
+		return 0
+	case reflect.Float32, reflect.Float64:
+		switch x, y := a.Float(), b.Float(); {
+		case x < y:
+			return -1
+		case x > y:
+			return +1
+		}
+		return 0
+	case reflect.Bool:
+		switch x, y := a.Bool(), b.Bool(); {
+		case !x && y:
+			return -1
+		case x && !y:
+			return +1
+		}
+		return 0
+	}
+
+	switch x, y := formatKey(a), formatKey(b); {
+	case x < y:
+		return -1
+	case x > y:
+		return +1
+	}
+	return 0
+}
+
+// kindClass returns the class of values of kind k which compare can compare
+// with each other, in the order in which the classes are sorted.
+func kindClass(k reflect.Kind) int {
+	switch k {
+	case reflect.Bool:
+		return 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return 1
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return 2
+	case reflect.Float32, reflect.Float64:
+		return 3
+	}
+	return 4
+}
+
+// A sorter sorts the elements of a collection by a list of fields, each of
+// which may be descending.  Elements which compare equal keep their order.
+type sorter struct {
+	elems []reflect.Value
+	index []int
+	paths [][]string
+	desc  []bool
+}
+
+func (s *sorter) Len() int { return len(s.elems) }
+
+func (s *sorter) Swap(i, j int) {
+	s.elems[i], s.elems[j] = s.elems[j], s.elems[i]
+	s.index[i], s.index[j] = s.index[j], s.index[i]
+}
+
+func (s *sorter) Less(i, j int) bool {
+	for k, path := range s.paths {
+		none := reflect.Value{}
+		cmp := compare(field(s.elems[i], none, path), field(s.elems[j], none, path))
+		if s.desc[k] {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return s.index[i] < s.index[j]
+}
+
+// query applies the filter and sort query parameters of the request to the
+// collection val and returns the result, which is of the same type as val
+// unless it is an array.  For slices and arrays, the original index of each
+// element of the result is also returned if it has changed.  Maps can be
+// filtered on their keys and elements but cannot be sorted.
+func (ent *entity) query(r *http.Request, val reflect.Value) (reflect.Value, []int, os.Error) {
+	q := r.URL.Query()
+	filters, order := q["filter"], q.Get("sort")
+	if len(filters) == 0 && order == "" {
+		return val, nil, nil
+	}
+	switch val.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return reflect.Value{}, nil, &BadQuery{ent.path, os.NewError("only collections can be filtered or sorted")}
+	}
+
+	var and [][]condition
+	for _, filter := range filters {
+		or, err := parseFilter(filter)
+		if err != nil {
+			return reflect.Value{}, nil, &BadQuery{ent.path, err}
+		}
+		and = append(and, or)
+	}
+	match := func(elem, key reflect.Value) bool {
+		for _, or := range and {
+			any := false
+			for i := range or {
+				if or[i].match(elem, key) {
+					any = true
+					break
+				}
+			}
+			if !any {
+				return false
+			}
+		}
+		return true
+	}
+
+	if val.Kind() == reflect.Map {
+		if order != "" {
+			return reflect.Value{}, nil, &BadQuery{ent.path, os.NewError("maps cannot be sorted")}
+		}
+		if val.IsNil() {
+			return val, nil, nil
+		}
+		out := reflect.MakeMap(val.Type())
+		for _, key := range val.MapKeys() {
+			if elem := val.MapIndex(key); match(elem, key) {
+				out.SetMapIndex(key, elem)
+			}
+		}
+		return out, nil, nil
+	}
+
+	s := new(sorter)
+	for i := 0; i < val.Len(); i++ {
+		if elem := val.Index(i); match(elem, reflect.Value{}) {
+			s.elems = append(s.elems, elem)
+			s.index = append(s.index, i)
+		}
+	}
+	if order != "" {
+		for _, name := range strings.Split(order, ",") {
+			desc := strings.HasPrefix(name, "-")
+			if desc {
+				name = name[1:]
+			}
+			if name == "" {
+				return reflect.Value{}, nil, &BadQuery{ent.path, os.NewError("invalid sort " + strconv.Quote(order))}
+			}
+			s.paths = append(s.paths, strings.Split(strings.ToLower(name), "."))
+			s.desc = append(s.desc, desc)
+		}
+		sort.Sort(s)
+	}
+
+	var out reflect.Value
+	if val.Kind() == reflect.Slice {
+		out = reflect.MakeSlice(val.Type(), len(s.elems), len(s.elems))
+	} else {
+		out = reflect.ValueOf(make([]interface{}, len(s.elems)))
+	}
+	for i, elem := range s.elems {
+		out.Index(i).Set(elem)
+	}
+	return out, s.index, nil
+}
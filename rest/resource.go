@@ -1,7 +1,9 @@
 package rest
 
 import (
+	"bytes"
 	"http"
+	"io/ioutil"
 	"json"
 	"os"
 	"reflect"
@@ -17,7 +19,27 @@ type Resource struct {
 	path  string
 	kind  reflect.Kind
 	value reflect.Value
+	props []*property
 	lock  sync.RWMutex
+
+	// changes wakes those watching the resource when it is modified.
+	changes notifier
+
+	// file is the file in which the resource is persisted, if any.
+	file string
+
+	// journal records the requests which modify the resource, if any.
+	journal *journal
+
+	// held is set while a batch is made to the resource, so that its
+	// requests are not recorded until the batch commits.
+	held bool
+
+	// version counts the modifications of the resource, the latest keep of
+	// which are in its history.
+	version int64
+	keep    int
+	history []*Version
 }
 
 // ReadOnly returns true if the Resource is read-only.
@@ -28,7 +50,6 @@ func (res *Resource) SetReadOnly() { res.ro = true }
 
 // ServeREST handles a RESTful HTTP request.
 func (res *Resource) ServeREST(w http.ResponseWriter, r *http.Request) os.Error {
-	value := res.value
 	path := r.URL.Path
 
 	// Make sure the path has the proper prefix
@@ -42,34 +63,309 @@ func (res *Resource) ServeREST(w http.ResponseWriter, r *http.Request) os.Error
 		path = path[:len(path)-1]
 	}
 
+	if isDryRun(r) {
+		return res.dryRun(w, r, path)
+	}
+	return res.serve(w, r, path)
+}
+
+// serve handles a request for the entity at path below the resource.
+func (res *Resource) serve(w http.ResponseWriter, r *http.Request, path string) os.Error {
+	if wantsWatch(r) {
+		return res.watch(w, r, path)
+	}
+	if wantsDiff(r) {
+		return res.serveDiff(w, r, path)
+	}
+	if modifies(r) && len(res.history) == 0 {
+		res.remember()
+	}
+	if wantsHistory(r) {
+		return res.serveHistory(w, r, path)
+	}
+
+	ent, err := res.resolve(path, r.Method == "PUT")
+	if err != nil {
+		return err
+	}
+
+	// Calls to functions are not journaled, since replaying the journal would
+	// make them again; the journal is compacted after them instead
+	var body []byte
+	replay := r
+	if ent.value.Kind() == reflect.Func {
+		replay = nil
+	} else if res.journal != nil && modifies(r) && ent.value.Kind() != reflect.Chan {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return &FailedDecode{err, r.Header.Get("Content-Type"), ent.value.Interface()}
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
+
+	// Sending on a channel does not modify the resource
+	if !modifies(r) || ent.value.Kind() == reflect.Chan {
+		return ent.serve(w, r)
+	}
+
+	// Hold the reply until the change has been journaled and saved, so that
+	// the client is told if either fails.  The change is then undone, unless
+	// it was a call, which cannot be.
+	var u undo
+	saved := false
+	if res.persisted() && ent.value.Kind() != reflect.Func {
+		u, saved = res.saveUndo(r.Method, path)
+	}
+	reply := &recorder{header: http.Header{}}
+	if err := ent.serve(reply, r); err != nil {
+		return err
+	}
+	if err := res.modified(replay, body); err != nil {
+		if saved {
+			res.revert(u)
+		}
+		return err
+	}
+	return reply.send(w)
+}
+
+// modifies returns true if the request may modify the resource.
+func modifies(r *http.Request) bool {
+	switch r.Method {
+	case "PUT", "PATCH", "POST", "DELETE":
+		return true
+	}
+	return false
+}
+
+// modified is called, with the resource locked, after a request with the
+// given body modifies it, or with a nil request after it is replaced
+// otherwise.  It journals the request (or compacts the journal, if the change
+// cannot be replayed) and saves the resource, as required, and then adds the
+// new version to the history and wakes those watching the resource.  If the
+// change cannot be journaled or saved, the version is unchanged and the
+// caller must undo the change.  While a batch is made to the resource, it
+// does nothing.
+func (res *Resource) modified(r *http.Request, body []byte) os.Error {
+	if res.held {
+		return nil
+	}
+	res.version++
+	if err := res.persist(r, body); err != nil {
+		res.version--
+		return err
+	}
+	res.remember()
+	res.changes.notify()
+	return nil
+}
+
+// persist journals the request with the given body, or if it is nil,
+// compacts the journal, and saves the resource, as required.  The resource
+// must be locked.
+func (res *Resource) persist(r *http.Request, body []byte) os.Error {
+	if r == nil || res.journal == nil {
+		files, err := res.stage()
+		if err != nil {
+			return err
+		}
+		return res.commit(files)
+	}
+
+	f, err := res.stageSave()
+	if err != nil {
+		return err
+	}
+	if err := res.record(r, body); err != nil {
+		if f != nil {
+			f.discard()
+		}
+		return err
+	}
+	if f == nil {
+		return nil
+	}
+	return f.commit()
+}
+
+// stage writes the files which are replaced after the resource is modified
+// other than by a journaled request, the snapshot of its journal and the file
+// in which it is persisted, to temporary files; the resource must be locked.
+func (res *Resource) stage() ([]*stagedFile, os.Error) {
+	var files []*stagedFile
+	for _, stage := range []func() (*stagedFile, os.Error){res.stageCompact, res.stageSave} {
+		f, err := stage()
+		if err != nil {
+			discardFiles(files)
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// commit replaces the files of the resource with those staged, and empties
+// its journal, since the new snapshot includes its entries.
+func (res *Resource) commit(files []*stagedFile) os.Error {
+	for i, f := range files {
+		if err := f.commit(); err != nil {
+			discardFiles(files[i+1:])
+			return err
+		}
+	}
+	if res.journal != nil {
+		res.journal.truncate()
+	}
+	return nil
+}
+
+// discardFiles removes staged files which are not to be committed.
+func discardFiles(files []*stagedFile) {
+	for _, f := range files {
+		f.discard()
+	}
+}
+
+// An entity is a value reached by walking a path below a Resource.  If the
+// value is an element of a map or slice, the collection and the key (or index)
+// are also recorded so that the element can be replaced or removed.  If the
+// value is held in an interface, the interface is recorded so that its dynamic
+// value can be replaced.  Nil pointers and maps through which a write passes
+// are only allocated (in allocs) when the entity is set.
+type entity struct {
+	ro      bool
+	path    string
+	value   reflect.Value
+	iface   reflect.Value
+	parent  reflect.Value
+	key     reflect.Value
+	props   []*property
+	prop    *property
+	name    string
+	created bool
+	allocs  []alloc
+}
+
+// An alloc is a nil pointer or map, and the value to store in it if the
+// entity below it is set.
+type alloc struct {
+	dst, val reflect.Value
+}
+
+// resolve walks path from the root of the resource and returns the entity it
+// names.  If create is true, the last element of the path may name a map key
+// that does not exist yet; the entity will then hold the zero value for the
+// element and will be stored into the map when it is set.
+func (res *Resource) resolve(path string, create bool) (*entity, os.Error) {
+	ent := &entity{
+		ro:    res.ro,
+		path:  res.path,
+		props: res.props,
+	}
+	ent.bind(res.value)
+
 	for curr, next := path, ""; len(curr) > 0; curr, next = next, "" {
 		if idx := strings.IndexRune(curr, '/'); idx >= 0 {
 			curr, next = curr[:idx], curr[idx+1:]
 		}
 
-		// TODO(kevlar): check function
-		for value.Kind() == reflect.Ptr {
-			// TODO(kevlar): avoid nil dereference
-			value = value.Elem()
+		value := ent.value
+		sub := &entity{
+			ro:     res.ro,
+			path:   subPath(ent.path, curr),
+			allocs: ent.allocs,
+		}
+
+		// Only a write may pass through a nil pointer, and it allocates it
+		switch value.Kind() {
+		case reflect.Ptr:
+			if !create || !value.CanSet() {
+				return nil, &BadSub{res.path, path, res.value.Interface()}
+			}
+			value, sub.allocs = allocate(value, sub.allocs)
+		case reflect.Interface:
+			if value.IsNil() {
+				return nil, &BadSub{res.path, path, res.value.Interface()}
+			}
+		}
+
+		if prop := lookup(ent.props, curr); prop != nil {
+			val, err := prop.get()
+			if err != nil {
+				return nil, err
+			}
+			sub.bind(val)
+			sub.prop = prop
+			ent = sub
+			continue
 		}
 
 		switch value.Kind() {
 		case reflect.Array, reflect.Slice:
-			idx, err := strconv.Atoi(curr)
-			if err != nil || idx < 0 || idx > value.Len() {
-				break
+			field := keyField(value.Type().Elem())
+			idx := -1
+			if field >= 0 {
+				if idx = findKey(value, field, curr); idx >= 0 {
+					sub.name = curr
+				}
+			}
+			if idx < 0 {
+				// Negative indices count back from the end
+				n, err := strconv.Atoi(curr)
+				switch {
+				case err != nil:
+				case n >= 0 && n < value.Len():
+					idx = n
+				case n < 0 && -n <= value.Len():
+					idx = value.Len() + n
+				}
+			}
+			if idx < 0 {
+				// A new element may be named by its key, or by "-" (as in a
+				// JSON Pointer) to append it
+				if field < 0 && curr != "-" || !create || len(next) > 0 || value.Kind() != reflect.Slice {
+					break
+				}
+				sub.bind(reflect.Zero(value.Type().Elem()))
+				sub.parent, sub.key = value, reflect.ValueOf(value.Len())
+				sub.created = true
+				if curr == "-" {
+					sub.path = subPath(ent.path, strconv.Itoa(value.Len()))
+				} else {
+					sub.name = curr
+				}
+				ent = sub
+				continue
 			}
-			value = value.Index(idx)
+			sub.bind(value.Index(idx))
+			sub.parent, sub.key = value, reflect.ValueOf(idx)
+			ent = sub
 			continue
 		case reflect.Map:
-			if value.Type().Key().Kind() != reflect.String {
+			key := parseKey(value.Type().Key(), curr)
+			if !key.IsValid() {
 				break
 			}
-			elem := value.MapIndex(reflect.ValueOf(curr))
+			if value.IsNil() {
+				if !create || len(next) > 0 || !value.CanSet() {
+					break
+				}
+				m := reflect.MakeMap(value.Type())
+				sub.allocs = append(sub.allocs, alloc{value, m})
+				value = m
+			}
+			elem := value.MapIndex(key)
 			if !elem.IsValid() {
-				break
+				if !create || len(next) > 0 {
+					break
+				}
+				elem = reflect.Zero(value.Type().Elem())
+				sub.created = true
 			}
-			value = elem
+			sub.bind(elem)
+			sub.parent, sub.key = value, key
+			ent = sub
 			continue
 		case reflect.Struct:
 			lower := strings.ToLower(curr)
@@ -79,83 +375,328 @@ func (res *Resource) ServeREST(w http.ResponseWriter, r *http.Request) os.Error
 			if !field.IsValid() {
 				break
 			}
-			value = field
+			if field.Kind() == reflect.Func && field.IsNil() {
+				break
+			}
+			sub.bind(field)
+			ent = sub
+			continue
+		}
+
+		// Methods of the value may be called as the last element of the path
+		if m := method(value, curr); m.IsValid() && len(next) == 0 {
+			sub.value = m
+			ent = sub
 			continue
 		}
-		return &BadSub{res.path, path, res.value.Interface()}
+		return nil, &BadSub{res.path, path, res.value.Interface()}
 	}
 
+	return ent, nil
+}
+
+// bind sets the value of the entity to the value ultimately referred to by
+// raw, recording raw if it is an interface.
+func (ent *entity) bind(raw reflect.Value) {
+	if raw.Kind() == reflect.Interface {
+		ent.iface = raw
+	}
+	ent.value = indirect(raw)
+}
+
+// indirect follows pointers and interfaces until it reaches a value of some
+// other kind or a nil pointer or interface.
+func indirect(value reflect.Value) reflect.Value {
+	for k := value.Kind(); (k == reflect.Ptr || k == reflect.Interface) && !value.IsNil(); k = value.Kind() {
+		value = value.Elem()
+	}
+	return value
+}
+
+// allocate follows pointers until it reaches a non-pointer value, allocating
+// values for any nil pointers along the way.  The pointers are not set; they
+// are added to allocs instead.
+func allocate(value reflect.Value, allocs []alloc) (reflect.Value, []alloc) {
 	for value.Kind() == reflect.Ptr {
-		// TODO(kevlar): avoid nil dereference
+		if value.IsNil() {
+			ptr := reflect.New(value.Type().Elem())
+			allocs = append(allocs, alloc{value, ptr})
+			value = ptr
+		}
 		value = value.Elem()
 	}
+	return value, allocs
+}
+
+// attach stores the values allocated for the nil pointers and maps above the
+// entity, once it has been set.
+func (ent *entity) attach() {
+	for _, a := range ent.allocs {
+		a.dst.Set(a.val)
+	}
+	ent.allocs = nil
+}
+
+// subPath returns the path of the sub-entity seg below the entity at base.
+func subPath(base, seg string) string {
+	if strings.HasSuffix(base, "/") {
+		return base + seg
+	}
+	return base + "/" + seg
+}
+
+// settable returns true if the entity can be replaced.
+func (ent *entity) settable() bool {
+	if ent.ro {
+		return false
+	}
+	if ent.prop != nil {
+		return ent.prop.setter.IsValid()
+	}
+	if ent.created {
+		return true
+	}
+	return ent.value.CanSet() || ent.iface.CanSet() || ent.parent.Kind() == reflect.Map
+}
+
+// set replaces the value of the entity with val.  If the entity is held in an
+// interface and cannot be set directly, the interface is set to val instead.
+func (ent *entity) set(val reflect.Value) os.Error {
+	if err := ent.checkKey(val); err != nil {
+		return err
+	}
+	if err := validate(ent.path, val); err != nil {
+		return err
+	}
+
+	switch {
+	case ent.created && ent.parent.Kind() == reflect.Slice:
+		if !ent.parent.CanSet() {
+			return &Unsettable{ent.path, ent.parent.Interface()}
+		}
+		ent.parent.Set(reflect.Append(ent.parent, val))
+	case ent.prop != nil && ent.prop.setter.IsValid():
+		if err := ent.prop.set(val); err != nil {
+			return &Invalid{ent.path, err}
+		}
+	case ent.value.CanSet():
+		ent.value.Set(val)
+	case ent.iface.CanSet():
+		ent.iface.Set(val)
+	case ent.parent.Kind() == reflect.Map:
+		ent.parent.SetMapIndex(ent.key, val)
+	default:
+		return &Unsettable{ent.path, ent.value.Interface()}
+	}
+	ent.attach()
+	return nil
+}
+
+// A Validator is a value which can check itself before it is stored.  If a
+// value written by a client implements Validator and Validate returns an
+// error, the value is not stored and the error is sent to the client.
+type Validator interface {
+	Validate() os.Error
+}
+
+// validate checks val (or, if it is addressable, a pointer to it) with its
+// Validate method, if it has one.
+func validate(path string, val reflect.Value) os.Error {
+	var v interface{}
+	switch {
+	case !val.IsValid():
+		return nil
+	case val.CanAddr():
+		v = val.Addr().Interface()
+	default:
+		v = val.Interface()
+	}
+	if v, ok := v.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return &Invalid{path, err}
+		}
+	}
+	return nil
+}
 
-	switch res.kind {
+// methods returns the HTTP methods supported by the entity in the order in
+// which they are listed in an Allow header, or nil if the type of the entity
+// is not supported.
+func (ent *entity) methods() []string {
+	allow := []string{"OPTIONS", "HEAD", "GET"}
+	settable := ent.settable()
+
+	// Newly created map elements may be nil pointers, so use the type.
+	t := ent.value.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Chan:
+		return chanMethods(t, ent.ro)
+	case reflect.Func:
+		// Functions are called, so they may only be retrieved if they are
+		// getters, and they cannot be replaced or removed.
+		if t.IsVariadic() {
+			return nil
+		}
+		allow = allow[:1]
+		if getter(t) {
+			allow = append(allow, "HEAD", "GET")
+		}
+		if !ent.ro {
+			allow = append(allow, "POST")
+		}
+		return allow
 	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
 		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
 		reflect.String:
-		return serveSimple(value, w, r)
-	case reflect.Array, reflect.Slice:
-		return serveCollection(value, w, r)
+		if settable {
+			allow = append(allow, "PUT")
+		}
+	case reflect.Slice:
+		if settable {
+			allow = append(allow, "POST", "PUT")
+		}
 	case reflect.Map:
-		return serveCollection(value, w, r)
+		if settable {
+			allow = append(allow, "POST", "PATCH", "PUT")
+		}
 	case reflect.Struct:
-		return serveObject(value, w, r)
+		if settable {
+			allow = append(allow, "PATCH", "PUT")
+		}
+	case reflect.Array, reflect.Interface:
+		if settable {
+			allow = append(allow, "PUT")
+		}
 	default:
-		return &UnhandledType{r.URL.Path, res.value.Interface()}
+		return nil
 	}
 
-	panic("unreachable")
+	if ent.ro {
+		return allow
+	}
+	switch ent.parent.Kind() {
+	case reflect.Map:
+		allow = append(allow, "DELETE")
+	case reflect.Slice:
+		if ent.parent.CanSet() {
+			allow = append(allow, "DELETE")
+		}
+	}
+	return allow
 }
 
-func serveSimple(val reflect.Value, w http.ResponseWriter, r *http.Request) os.Error {
-	switch r.Method {
-	case "HEAD", "GET", "PUT":
-	default:
-		return &BadMethod{r.URL.Path, r.Method, val.Interface()}
+// serve dispatches the request to the handler for its method.
+func (ent *entity) serve(w http.ResponseWriter, r *http.Request) os.Error {
+	allow := ent.methods()
+	if allow == nil {
+		return &UnhandledType{ent.path, ent.value.Interface()}
 	}
 
-	ctype := "application/json"
-	// TODO(kevlar): Content type negotiation
-	w.Header().Set("Content-Type", ctype)
-
-	if r.Method == "HEAD" {
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
 		return nil
 	}
-
-	js, err := json.Marshal(val.Interface())
-	if err != nil {
-		return &FailedEncode{err,ctype,val.Interface()}
+	if (r.Method == "GET" || r.Method == "HEAD") && wantsSchema(r) {
+		return ent.serveSchema(w, r)
 	}
-
-	if _, err := w.Write(js); err != nil {
+	if err := ent.precondition(r); err != nil {
 		return err
 	}
 
-	return nil
+	for _, method := range allow {
+		if method != r.Method {
+			continue
+		}
+		switch ent.value.Kind() {
+		case reflect.Func:
+			return ent.call(w, r)
+		case reflect.Chan:
+			if method == "POST" {
+				return ent.send(w, r)
+			}
+			return ent.recv(w, r)
+		}
+		switch method {
+		case "HEAD", "GET":
+			return ent.get(w, r)
+		case "PUT":
+			return ent.put(w, r)
+		case "PATCH":
+			return ent.patch(w, r)
+		case "POST":
+			return ent.post(w, r)
+		case "DELETE":
+			return ent.del(w, r)
+		}
+	}
+	return &BadMethod{ent.path, r.Method, ent.value.Interface(), allow}
 }
 
-func serveCollection(val reflect.Value, w http.ResponseWriter, r *http.Request) os.Error {
-	switch r.Method {
-	case "HEAD", "GET", "PUT", "POST":
-	default:
-		return &BadMethod{r.URL.Path, r.Method, val.Interface()}
+func (ent *entity) get(w http.ResponseWriter, r *http.Request) os.Error {
+	mt := Negotiate(r.Header["Accept"])
+	if mt == nil {
+		return &NotAcceptable{ent.path, r.Header["Accept"]}
 	}
-
-	ctype := "application/json"
-	// TODO(kevlar): Content type negotiation
+	ctype := mt.Type + "/" + mt.SubType
 	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Vary", "Accept")
 
-	if r.Method == "HEAD" {
-		return nil
+	val, index, err := ent.query(r, ent.value)
+	if err != nil {
+		return err
+	}
+	status := http.StatusOK
+	if k := val.Kind(); k == reflect.Slice || k == reflect.Array {
+		if val, index, status, err = ent.page(w, r, val, index); err != nil {
+			return err
+		}
 	}
 
-	js, err := json.Marshal(val.Interface())
+	var js []byte
+	q := r.URL.Query()
+	fields, depth := q.Get("fields"), q.Get("depth")
+	switch {
+	case ctype == "application/hal+json":
+		js, err = ent.encode(val, index, fields, depth, true)
+	case ctype != "application/json":
+		js, err = LookupCodec(ctype).Encode(val, ent.path)
+	case fields != "" || depth != "":
+		js, err = ent.encode(val, index, fields, depth, false)
+	default:
+		v := val.Interface()
+		if val.Kind() == reflect.Map {
+			v = stringKeys(val)
+		}
+		js, err = json.Marshal(v)
+		if err == nil && len(ent.props) > 0 {
+			js, err = addProperties(js, ent.props)
+		}
+	}
 	if err != nil {
-		return &FailedEncode{err,ctype,val.Interface()}
+		if _, ok := err.(*BadQuery); ok {
+			return err
+		}
+		return &FailedEncode{err, ctype, ent.value.Interface()}
 	}
 
+	// The tag is that of the representation sent, which depends on the query
+	// and media type as well as the value
+	tag := hashTag(js)
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchTag(inm, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.WriteHeader(status)
+	if r.Method == "HEAD" {
+		return nil
+	}
 	if _, err := w.Write(js); err != nil {
 		return err
 	}
@@ -163,30 +704,165 @@ func serveCollection(val reflect.Value, w http.ResponseWriter, r *http.Request)
 	return nil
 }
 
-func serveObject(val reflect.Value, w http.ResponseWriter, r *http.Request) os.Error {
-	switch r.Method {
-	case "HEAD", "GET", "PUT":
-	default:
-		return nil
-		return &BadMethod{r.URL.Path, r.Method, val.Interface()}
+// put replaces the entity with the request body.  Structures are updated in
+// place, so only the fields present in the body are changed.  The body is
+// checked against the schema of the entity before it is decoded.
+func (ent *entity) put(w http.ResponseWriter, r *http.Request) os.Error {
+	if err := ent.checkSchema(r); err != nil {
+		return err
 	}
 
-	ctype := "application/json"
-	// TODO(kevlar): Content type negotiation
-	w.Header().Set("Content-Type", ctype)
+	var val reflect.Value
+	var err os.Error
+	if ent.iface.IsValid() {
+		if val, err = ent.decodeDynamic(r); err != nil {
+			return err
+		}
+		if err := ent.setDynamic(val); err != nil {
+			return err
+		}
+	} else {
+		init := reflect.Zero(ent.value.Type())
+		if ent.value.Kind() == reflect.Struct {
+			init = ent.value
+		}
 
-	if r.Method == "HEAD" {
+		if val, err = decode(r, init); err != nil {
+			return err
+		}
+		if err := ent.set(val); err != nil {
+			return err
+		}
+	}
+
+	if tag := ent.etag(val); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	if ent.created {
+		w.Header().Set("Location", ent.path)
+		w.WriteHeader(http.StatusCreated)
 		return nil
 	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
 
-	js, err := json.Marshal(val.Interface())
-	if err != nil {
-		return &FailedEncode{err,ctype,val.Interface()}
+// post adds the request body to the collection.  For a slice, the body is a
+// single element to append; for a map, the body is a map whose elements are
+// added to (or replace those in) the collection.
+func (ent *entity) post(w http.ResponseWriter, r *http.Request) os.Error {
+	val := ent.value
+
+	switch val.Kind() {
+	case reflect.Slice:
+		elem, err := decode(r, reflect.Zero(val.Type().Elem()))
+		if err != nil {
+			return err
+		}
+		loc := subPath(ent.path, strconv.Itoa(val.Len()))
+		if field := keyField(elem.Type()); field >= 0 {
+			if k := indirect(elem); k.Kind() == reflect.Struct {
+				name := formatKey(k.Field(field))
+				if findKey(val, field, name) >= 0 {
+					return &Duplicate{ent.path, name}
+				}
+				loc = subPath(ent.path, name)
+			}
+		}
+		if err := validate(loc, elem); err != nil {
+			return err
+		}
+		if err := ent.set(reflect.Append(val, elem)); err != nil {
+			return err
+		}
+		w.Header().Set("Location", loc)
+	case reflect.Map:
+		add, err := decode(r, reflect.Zero(val.Type()))
+		if err != nil {
+			return err
+		}
+		if val.IsNil() {
+			val = reflect.MakeMap(val.Type())
+			if err := ent.set(val); err != nil {
+				return err
+			}
+		}
+		keys := add.MapKeys()
+		for _, key := range keys {
+			if err := validate(subPath(ent.path, formatKey(key)), add.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		for _, key := range keys {
+			val.SetMapIndex(key, add.MapIndex(key))
+		}
+		if len(keys) == 1 {
+			w.Header().Set("Location", subPath(ent.path, formatKey(keys[0])))
+		}
 	}
 
-	if _, err := w.Write(js); err != nil {
-		return err
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// del removes the entity from its containing map or slice.
+func (ent *entity) del(w http.ResponseWriter, r *http.Request) os.Error {
+	switch p := ent.parent; p.Kind() {
+	case reflect.Map:
+		p.SetMapIndex(ent.key, reflect.Value{})
+	case reflect.Slice:
+		idx := int(ent.key.Int())
+		p.Set(reflect.AppendSlice(p.Slice(0, idx), p.Slice(idx+1, p.Len())))
 	}
 
+	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
+
+// decode reads a JSON request body into a new value of the same type as init,
+// which is copied into the new value before decoding.  The keys of a map are
+// parsed in the same way as they are in paths.
+func decode(r *http.Request, init reflect.Value) (reflect.Value, os.Error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return reflect.Value{}, &FailedDecode{err, "application/json", init.Interface()}
+	}
+	return decodeJSON(body, init)
+}
+
+// decodeJSON decodes JSON in the same way as decode.
+func decodeJSON(data []byte, init reflect.Value) (reflect.Value, os.Error) {
+	ptr := reflect.New(init.Type())
+	ptr.Elem().Set(init)
+
+	ctype := "application/json"
+	if t := init.Type(); t.Kind() == reflect.Map && t.Key().Kind() != reflect.String {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return reflect.Value{}, &FailedDecode{err, ctype, init.Interface()}
+		}
+		if raw == nil {
+			return ptr.Elem(), nil
+		}
+
+		m := reflect.MakeMap(t)
+		for seg, js := range raw {
+			key := parseKey(t.Key(), seg)
+			if !key.IsValid() {
+				err := os.NewError("invalid key " + strconv.Quote(seg))
+				return reflect.Value{}, &FailedDecode{err, ctype, init.Interface()}
+			}
+			elem := reflect.New(t.Elem())
+			if err := json.Unmarshal(js, elem.Interface()); err != nil {
+				return reflect.Value{}, &FailedDecode{err, ctype, init.Interface()}
+			}
+			m.SetMapIndex(key, elem.Elem())
+		}
+		return m, nil
+	}
+
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return reflect.Value{}, &FailedDecode{err, ctype, init.Interface()}
+	}
+	return ptr.Elem(), nil
+}
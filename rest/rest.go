@@ -25,6 +25,7 @@ func mapValue(path string, value reflect.Value) (*Resource, os.Error) {
 		path:  path,
 		kind:  value.Kind(),
 		value: value,
+		keep:  HistorySize,
 	}
 
 	Handle(path, r)
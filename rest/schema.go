@@ -0,0 +1,387 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io/ioutil"
+	"json"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A schema is a JSON Schema, as the object which encodes it.
+type schema map[string]interface{}
+
+var (
+	marshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// opaque returns true if values of type t encode or decode themselves, so
+// that their representation cannot be derived from the type.
+func opaque(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return t.Implements(marshalerType) || pt.Implements(marshalerType) ||
+		t.Implements(unmarshalerType) || pt.Implements(unmarshalerType)
+}
+
+// A schemaBuilder derives JSON Schemas from Go types.  Named structures are
+// defined once, in defs, and referred to by "$ref" (with the given prefix) so
+// that recursive types can be described.
+type schemaBuilder struct {
+	ref  string
+	defs map[string]schema
+}
+
+func newSchemaBuilder(ref string) *schemaBuilder {
+	return &schemaBuilder{
+		ref:  ref,
+		defs: map[string]schema{},
+	}
+}
+
+// schema returns the schema of the JSON encoding of values of type t, or of
+// the values sent and received if t is a channel.  Values whose encoding
+// cannot be known (interfaces and types which encode themselves) are
+// described by the empty schema, which allows anything.
+func (b *schemaBuilder) schema(t reflect.Type) schema {
+	if opaque(t) {
+		return schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schema{"anyOf": []interface{}{b.schema(t.Elem()), schema{"type": "null"}}}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": []string{"string", "null"}, "contentEncoding": "base64"}
+		}
+		return schema{"type": []string{"array", "null"}, "items": b.schema(t.Elem())}
+	case reflect.Array:
+		return schema{"type": "array", "items": b.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return schema{"type": []string{"object", "null"}, "additionalProperties": b.schema(t.Elem())}
+	case reflect.Chan:
+		return b.schema(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := strings.Replace(t.String(), " ", "", -1)
+		if _, ok := b.defs[name]; !ok {
+			// Define the name before describing the fields, which may refer to it
+			b.defs[name] = schema{}
+			b.defs[name] = b.object(t)
+		}
+		return schema{"$ref": b.ref + name}
+	}
+	return schema{}
+}
+
+// object returns the schema of a structure of type t.  No fields are required,
+// because a PUT to a structure only replaces the fields which are present.
+func (b *schemaBuilder) object(t reflect.Type) schema {
+	props := schema{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			continue
+		}
+		if key, _ := jsonKey(f); key != "" {
+			props[key] = b.schema(f.Type)
+		}
+	}
+	return schema{"type": "object", "properties": props}
+}
+
+// withProps returns the schema s of the value at the root of a resource,
+// extended with its computed properties.  Properties without setters are
+// marked as read-only.
+func (b *schemaBuilder) withProps(s schema, props []*property) schema {
+	if len(props) == 0 {
+		return s
+	}
+
+	ps := schema{}
+	for _, prop := range props {
+		p := b.schema(prop.getter.Type().Out(0))
+		if !prop.setter.IsValid() {
+			p = schema{"allOf": []interface{}{p}, "readOnly": true}
+		}
+		ps[prop.name] = p
+	}
+	return schema{"allOf": []interface{}{s, schema{"properties": ps}}}
+}
+
+// SchemaType is the media type of JSON Schemas.
+const SchemaType = "application/schema+json"
+
+// defsRef is the prefix of references to the definitions in a JSON Schema.
+const defsRef = "#/$defs/"
+
+// wantsSchema returns true if the request asks for the schema of the entity,
+// with a schema query parameter or by accepting SchemaType.
+func wantsSchema(r *http.Request) bool {
+	if _, ok := r.URL.Query()["schema"]; ok {
+		return true
+	}
+	for _, mt := range ParseMediaTypes(r.Header["Accept"]) {
+		if mt.Type+"/"+mt.SubType == SchemaType && mt.Quality > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaType returns the type of the values which can be stored in the entity.
+func (ent *entity) schemaType() reflect.Type {
+	switch {
+	case ent.prop != nil:
+		return ent.prop.getter.Type().Out(0)
+	case ent.iface.IsValid():
+		return ent.iface.Type()
+	}
+	return ent.value.Type()
+}
+
+// schema returns the JSON Schema of the entity's value, with the definitions
+// of the structures it refers to.
+func (ent *entity) schema() schema {
+	b := newSchemaBuilder(defsRef)
+	s := b.withProps(b.schema(ent.schemaType()), ent.props)
+
+	doc := schema{"$schema": "https://json-schema.org/draft/2020-12/schema"}
+	for k, v := range s {
+		doc[k] = v
+	}
+	if len(b.defs) > 0 {
+		doc["$defs"] = b.defs
+	}
+	return doc
+}
+
+// serveSchema sends the JSON Schema of the entity's value.
+func (ent *entity) serveSchema(w http.ResponseWriter, r *http.Request) os.Error {
+	w.Header().Set("Content-Type", SchemaType)
+	if r.Method == "HEAD" {
+		return nil
+	}
+
+	js, err := json.Marshal(ent.schema())
+	if err != nil {
+		return &FailedEncode{err, SchemaType, ent.value.Interface()}
+	}
+	_, err = w.Write(js)
+	return err
+}
+
+// checkSchema validates the JSON request body against the schema of the
+// entity's value before it is decoded, and fails with every violation found.
+// Bodies which are not valid JSON are left for the decoder to reject.
+func (ent *entity) checkSchema(r *http.Request) os.Error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &FailedDecode{err, "application/json", ent.value.Interface()}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	var val interface{}
+	if err := json.Unmarshal(body, &val); err != nil {
+		return nil
+	}
+	return ent.checkValue(val)
+}
+
+// checkValue validates a decoded JSON value against the schema of the entity's
+// value, and fails with every violation found.
+func (ent *entity) checkValue(val interface{}) os.Error {
+	doc := ent.schema()
+	defs, _ := doc["$defs"].(map[string]schema)
+	v := &validator{defs: defs}
+	v.check(doc, val, "")
+	if len(v.violations) > 0 {
+		return &SchemaError{ent.path, v.violations}
+	}
+	return nil
+}
+
+// A Violation is a part of a value which does not conform to a schema.
+type Violation struct {
+	Pointer string // JSON Pointer to the part of the value
+	Message string
+}
+
+// A validator checks decoded JSON values against the subset of JSON Schema
+// produced by a schemaBuilder.
+type validator struct {
+	defs       map[string]schema
+	violations []Violation
+}
+
+func (v *validator) fail(ptr, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{ptr, fmt.Sprintf(format, args...)})
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(val interface{}) string {
+	switch val := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == float64(int64(val)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// check records the ways in which val, which is found at the JSON Pointer ptr,
+// violates the schema s.
+func (v *validator) check(s schema, val interface{}, ptr string) {
+	if ref, ok := s["$ref"].(string); ok {
+		def, ok := v.defs[strings.Replace(ref, defsRef, "", 1)]
+		if !ok {
+			v.fail(ptr, "unknown schema %s", ref)
+			return
+		}
+		v.check(def, val, ptr)
+	}
+
+	if list, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range list {
+			v.check(sub.(schema), val, ptr)
+		}
+	}
+
+	if list, ok := s["anyOf"].([]interface{}); ok && len(list) > 0 {
+		var first []Violation
+		for i, sub := range list {
+			alt := &validator{defs: v.defs}
+			alt.check(sub.(schema), val, ptr)
+			if len(alt.violations) == 0 {
+				first = nil
+				break
+			}
+			if i == 0 {
+				first = alt.violations
+			}
+		}
+		v.violations = append(v.violations, first...)
+	}
+
+	if t, ok := s["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []string:
+			types = t
+		}
+		got, match := jsonType(val), false
+		for _, want := range types {
+			if want == got || want == "number" && got == "integer" {
+				match = true
+			}
+		}
+		if !match {
+			v.fail(ptr, "got %s, want %s", got, strings.Join(types, " or "))
+			return
+		}
+	}
+
+	switch val := val.(type) {
+	case float64:
+		if min, ok := s["minimum"].(int); ok && val < float64(min) {
+			v.fail(ptr, "%v is less than the minimum %d", val, min)
+		}
+	case []interface{}:
+		if min, ok := s["minItems"].(int); ok && len(val) < min {
+			v.fail(ptr, "has %d items, want at least %d", len(val), min)
+		}
+		if max, ok := s["maxItems"].(int); ok && len(val) > max {
+			v.fail(ptr, "has %d items, want at most %d", len(val), max)
+		}
+		prefix, _ := s["prefixItems"].([]interface{})
+		for i, elem := range val {
+			p := ptr + "/" + strconv.Itoa(i)
+			if i < len(prefix) {
+				v.check(prefix[i].(schema), elem, p)
+				continue
+			}
+			switch items := s["items"].(type) {
+			case schema:
+				v.check(items, elem, p)
+			case bool:
+				if !items {
+					v.fail(p, "unexpected item")
+				}
+			}
+		}
+	case map[string]interface{}:
+		props, _ := s["properties"].(schema)
+		extra, _ := s["additionalProperties"].(schema)
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			elem := val[key]
+			p := ptr + "/" + escapePointer(key)
+			if ps := lookupMember(props, key); ps != nil {
+				v.check(ps, elem, p)
+			} else if extra != nil {
+				v.check(extra, elem, p)
+			}
+		}
+	}
+}
+
+// lookupMember returns the schema of the property named key, which (as in the
+// json decoder) is matched exactly if possible and otherwise without regard to
+// case, or nil if there is none.
+func lookupMember(props schema, key string) schema {
+	if ps, ok := props[key]; ok {
+		return ps.(schema)
+	}
+	lower := strings.ToLower(key)
+	for name, ps := range props {
+		if strings.ToLower(name) == lower {
+			return ps.(schema)
+		}
+	}
+	return nil
+}
+
+// escapePointer escapes a member name for use in a JSON Pointer.
+func escapePointer(name string) string {
+	name = strings.Replace(name, "~", "~0", -1)
+	return strings.Replace(name, "/", "~1", -1)
+}
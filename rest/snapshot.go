@@ -0,0 +1,179 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// Snapshot writes the value of the resource to w as JSON.
+func (res *Resource) Snapshot(w io.Writer) os.Error {
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+	return res.snapshot(w)
+}
+
+// snapshot writes the value of the resource to w; the resource must be locked.
+func (res *Resource) snapshot(w io.Writer) os.Error {
+	js, err := jsonCodec{}.Encode(res.value, res.path)
+	if err != nil {
+		return &FailedEncode{err, "application/json", res.value.Interface()}
+	}
+	_, err = w.Write(js)
+	return err
+}
+
+// Restore replaces the value of the resource with the snapshot read from r, as
+// a PUT of the whole value would, except that structures are replaced rather
+// than updated: fields which are encoded but not in the snapshot are reset to
+// their zero values.  The parts of the value which are not encoded, such as
+// unexported fields, channels and functions, are kept.  The resource must have
+// been mapped by pointer, but it need not be writable through the REST
+// interface.
+func (res *Resource) Restore(r io.Reader) os.Error {
+	res.lock.Lock()
+	defer res.lock.Unlock()
+	if len(res.history) == 0 {
+		res.remember()
+	}
+	var u undo
+	saved := false
+	if res.persisted() {
+		u, saved = res.saveUndo("PUT", "")
+	}
+	if err := res.restore(r); err != nil {
+		return err
+	}
+	if err := res.modified(nil, nil); err != nil {
+		if saved {
+			res.revert(u)
+		}
+		return err
+	}
+	return nil
+}
+
+// restore replaces the value of the resource; the resource must be locked.
+func (res *Resource) restore(r io.Reader) os.Error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	ent, err := res.resolve("", false)
+	if err != nil {
+		return err
+	}
+	if !ent.value.IsValid() {
+		return &Unsettable{ent.path, nil}
+	}
+	val, err := decodeJSON(data, replacement(ent.value))
+	if err != nil {
+		return err
+	}
+	return ent.set(val)
+}
+
+// Persist keeps the value of the resource in the named file.  If the file
+// exists, the value is first restored from it.  Afterwards, a snapshot is
+// written to the file after every request which modifies the resource.  It is
+// written to a temporary file which is then renamed over the old one, so the
+// file always holds a complete snapshot.
+func (res *Resource) Persist(file string) os.Error {
+	res.lock.Lock()
+	defer res.lock.Unlock()
+
+	if f, err := os.Open(file); err == nil {
+		defer f.Close()
+		if err := res.restore(f); err != nil {
+			return err
+		}
+	} else if _, serr := os.Stat(file); serr == nil {
+		return err
+	}
+
+	res.file = file
+	return res.save()
+}
+
+// save writes a snapshot to the file in which the resource is persisted, if
+// any; the resource must be locked.
+func (res *Resource) save() os.Error {
+	f, err := res.stageSave()
+	if err != nil || f == nil {
+		return err
+	}
+	return f.commit()
+}
+
+// stageSave writes a snapshot for the file in which the resource is
+// persisted, if any, to a temporary file; the resource must be locked.
+func (res *Resource) stageSave() (*stagedFile, os.Error) {
+	if res.file == "" {
+		return nil, nil
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := res.snapshot(buf); err != nil {
+		return nil, err
+	}
+	return stageFile(res.file, buf.Bytes())
+}
+
+// A stagedFile is a temporary file holding the new contents of the named
+// file, which it replaces when it is committed.
+type stagedFile struct {
+	tmp, name string
+}
+
+// stageFile writes data to a temporary file which can replace the named file,
+// so that the file is never left incomplete.
+func stageFile(name string, data []byte) (*stagedFile, os.Error) {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &stagedFile{tmp, name}, nil
+}
+
+// commit replaces the file with the temporary file, and syncs the directory
+// holding it so that the rename is durable.
+func (f *stagedFile) commit() os.Error {
+	if err := os.Rename(f.tmp, f.name); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(f.name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// discard removes the temporary file.
+func (f *stagedFile) discard() {
+	os.Remove(f.tmp)
+}
+
+// writeFile replaces the named file with one holding data.
+func writeFile(name string, data []byte) os.Error {
+	f, err := stageFile(name, data)
+	if err != nil {
+		return err
+	}
+	return f.commit()
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"reflect"
+	"strings"
+)
+
+// An undo record holds a copy of the value of the entity at path below a
+// resource, which encloses everything a request may modify, as it was before
+// the request.
+type undo struct {
+	path string
+	old  reflect.Value
+}
+
+// saveUndo returns an undo record for a request with the given method to
+// the entity at path below the resource, which may modify it.  It saves the
+// value of the nearest entity which exists and can be restored, and which
+// encloses the one the request names (or, for a DELETE, its container).  If
+// there is none, it returns false.  The resource must be locked.
+func (res *Resource) saveUndo(method, path string) (undo, bool) {
+	if method == "DELETE" {
+		path = parentPath(path)
+	}
+	for {
+		if ent, err := res.resolve(path, false); err == nil {
+			if v := ent.stored(); v.IsValid() {
+				return undo{path, deepCopy(v)}, true
+			}
+		}
+		if path == "" {
+			return undo{}, false
+		}
+		path = parentPath(path)
+	}
+	panic("unreachable")
+}
+
+// persisted returns true if the resource is saved or journaled after it is
+// modified, which may fail, so that changes to it must be undoable.
+func (res *Resource) persisted() bool {
+	return res.file != "" || res.journal != nil
+}
+
+// revert restores the entity saved in the undo record, once the requests
+// made after it was saved have been undone.  The resource must be locked.
+func (res *Resource) revert(u undo) {
+	if ent, err := res.resolve(u.path, false); err == nil {
+		ent.restore(u.old)
+	}
+}
+
+// parentPath returns the path of the entity containing the one at path.
+func parentPath(path string) string {
+	if idx := strings.LastIndex(path, "/"); idx >= 0 {
+		return path[:idx]
+	}
+	return ""
+}
+
+// stored returns the value through which the entity is stored, which can be
+// replaced to restore it: its element of a map, the interface holding it or
+// the value itself.  If it cannot be replaced, the value is invalid.
+func (ent *entity) stored() reflect.Value {
+	switch {
+	case ent.prop != nil:
+	case ent.parent.Kind() == reflect.Map:
+		return ent.parent.MapIndex(ent.key)
+	case ent.iface.CanSet():
+		return ent.iface
+	case ent.value.CanSet():
+		return ent.value
+	}
+	return reflect.Value{}
+}
+
+// restore replaces the value through which the entity is stored with old,
+// which was saved from it.  Maps are restored in place, since they may be
+// shared.
+func (ent *entity) restore(old reflect.Value) {
+	if cur := ent.stored(); cur.Kind() == reflect.Map && !cur.IsNil() && !old.IsNil() {
+		for _, key := range cur.MapKeys() {
+			if !old.MapIndex(key).IsValid() {
+				cur.SetMapIndex(key, reflect.Value{})
+			}
+		}
+		for _, key := range old.MapKeys() {
+			cur.SetMapIndex(key, old.MapIndex(key))
+		}
+		return
+	}
+
+	switch {
+	case ent.prop != nil:
+	case ent.parent.Kind() == reflect.Map:
+		ent.parent.SetMapIndex(ent.key, old)
+	case ent.iface.CanSet():
+		ent.iface.Set(old)
+	case ent.value.CanSet():
+		ent.value.Set(old)
+	}
+}
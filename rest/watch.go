@@ -0,0 +1,118 @@
+package rest
+
+import (
+	"http"
+	"os"
+	"sync"
+	"time"
+)
+
+// WatchType is the media type in which changes to a watched value are sent.
+const WatchType = "text/event-stream"
+
+// A notifier wakes the watchers of a Resource when it changes.
+type notifier struct {
+	lock sync.Mutex
+	ch   chan bool
+}
+
+// wait returns a channel which is closed at the next change.
+func (n *notifier) wait() <-chan bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan bool)
+	}
+	return n.ch
+}
+
+// notify wakes every watcher waiting for a change.
+func (n *notifier) notify() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// wantsWatch returns true if the request asks to watch the value it names.
+func wantsWatch(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	_, ok := r.URL.Query()["watch"]
+	return ok
+}
+
+// watch streams the value at path below the resource as server-sent events:
+// the current value when the watch begins, and the new value each time it
+// changes.  The value is sent as a GET of it would be in plain JSON
+// (including any properties), and the id of each event is its entity tag, so
+// that it matches the ETag of such a GET.  A client
+// which reconnects with it in the Last-Event-ID header is only sent the value
+// once it differs.  The stream ends when the value no longer exists or the
+// client goes away.
+func (res *Resource) watch(w http.ResponseWriter, r *http.Request, path string) os.Error {
+	ent, err := res.resolve(path, false)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", WatchType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == "HEAD" {
+		return nil
+	}
+
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = func() { f.Flush() }
+	}
+
+	// The lock is taken for each change instead
+	unlock(w)
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	last := r.Header.Get("Last-Event-ID")
+	for {
+		res.lock.RLock()
+		changed := res.changes.wait()
+		ent, err = res.resolve(path, false)
+		var tag string
+		var js []byte
+		if err == nil {
+			js, err = jsonCodec{}.Encode(ent.value, ent.path)
+			if err == nil && len(ent.props) > 0 {
+				js, err = addProperties(js, ent.props)
+			}
+			tag = hashTag(js)
+		}
+		res.lock.RUnlock()
+		if err != nil {
+			return nil
+		}
+
+		if tag != last {
+			if _, err := w.Write([]byte("id: " + tag + "\ndata: " + string(js) + "\n\n")); err != nil {
+				return nil
+			}
+			flush()
+			last = tag
+		}
+
+		for idle := true; idle; {
+			select {
+			case <-changed:
+				idle = false
+			case <-time.After(Heartbeat):
+				if _, err := w.Write([]byte(":\n\n")); err != nil {
+					return nil
+				}
+				flush()
+			}
+		}
+	}
+
+	panic("unreachable")
+}